@@ -0,0 +1,185 @@
+package authn
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcmorel/morm"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//ErrInvalidAPIKey is returned for unknown, revoked or expired API keys
+var ErrInvalidAPIKey = errors.New("Invalid API key")
+
+//apiKeyPrefix starts every API key : mk_<prefix>_<secret>
+const apiKeyPrefix = "mk_"
+
+//lastUsedPrecision avoids writing the last used date on every single call
+const lastUsedPrecision = time.Minute
+
+//APIKey gives a machine access to mauth on behalf of a user of a domain.
+//Service accounts are users without password. Only the hash of the key is stored,
+//the prefix is kept readable to identify the key in lists.
+//RoleIDs restricts the checks made with the key to a subset of roles, all roles if empty
+type APIKey struct {
+	ID         uint64     `db:"id" json:"id"`
+	DomainID   uint64     `db:"domain_id" json:"domain_id"`
+	UserID     uint64     `db:"user_id" json:"user_id"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	Hash       string     `db:"hash" json:"-"`
+	RoleIDs    string     `db:"role_ids" json:"role_ids"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+func apiKeyFromRow(row map[string]interface{}) *APIKey {
+	return &APIKey{
+		ID:         tools.RowUint(row, "id"),
+		DomainID:   tools.RowUint(row, "domain_id"),
+		UserID:     tools.RowUint(row, "user_id"),
+		Name:       morm.SafeString(row["name"]),
+		Prefix:     morm.SafeString(row["prefix"]),
+		Hash:       morm.SafeString(row["hash"]),
+		RoleIDs:    morm.SafeString(row["role_ids"]),
+		ExpiresAt:  morm.SafeTime(row["expires_at"]),
+		LastUsedAt: morm.SafeTime(row["last_used_at"]),
+		RevokedAt:  morm.SafeTime(row["revoked_at"]),
+		CreatedAt:  tools.RowTime(row, "created_at"),
+	}
+}
+
+//CreateAPIKey creates a key for a user. The key is returned once and cannot be read again.
+//roleIDs restricts the key to some roles of the domain of the user, nil for all of them
+func CreateAPIKey(u *authz.User, name string, expiresAt *time.Time, roleIDs []uint64) (*APIKey, string, error) {
+	ids := make([]string, len(roleIDs))
+	for i, id := range roleIDs {
+		role, err := authz.GetRoleByID(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if role.DomainID != u.DomainID {
+			return nil, "", authz.ErrDomainMismatch
+		}
+		ids[i] = tools.FormatID(id)
+	}
+	k, key := generateAPIKey(u, name, expiresAt, strings.Join(ids, ","))
+	if err := morm.Create(k); err != nil {
+		return nil, "", err
+	}
+	return k, key, nil
+}
+
+//generateAPIKey draws a new key for a user and returns it along with its record, not stored yet
+func generateAPIKey(u *authz.User, name string, expiresAt *time.Time, roleIDs string) (*APIKey, string) {
+	prefix := apiKeyPrefix + tools.RandomHex(4)
+	key := prefix + "_" + tools.RandomHex(24)
+	return &APIKey{
+		DomainID:  u.DomainID,
+		UserID:    u.ID,
+		Name:      strings.TrimSpace(name),
+		Prefix:    prefix,
+		Hash:      tools.HashToken(key),
+		RoleIDs:   roleIDs,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}, key
+}
+
+//apiKeyLookupPrefix returns the prefix identifying the record of a key, false if the key is malformed
+func apiKeyLookupPrefix(key string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return apiKeyPrefix + parts[0], true
+}
+
+//accepts tells if the record matches a secret key and is neither revoked nor expired at a given time
+func (k *APIKey) accepts(key string, at time.Time) bool {
+	return subtle.ConstantTimeCompare([]byte(k.Hash), []byte(tools.HashToken(key))) == 1 &&
+		k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(at))
+}
+
+//GetAPIKeyByID returns a key or ErrNotFound
+func GetAPIKeyByID(id uint64) (*APIKey, error) {
+	row, err := tools.FindOneRow("api_key", map[string]string{"id": tools.FormatID(id)})
+	if err != nil {
+		return nil, err
+	}
+	return apiKeyFromRow(row), nil
+}
+
+//GetUserAPIKeys returns the keys of a user, revoked ones included
+func GetUserAPIKeys(userID uint64) ([]*APIKey, error) {
+	rows, err := tools.FindAllRows("api_key", map[string]string{"user_id": tools.FormatID(userID), "morm_orderby": "created_at desc"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*APIKey, len(rows))
+	for i, row := range rows {
+		result[i] = apiKeyFromRow(row)
+	}
+	return result, nil
+}
+
+//AuthenticateAPIKey returns the valid key matching a secret key and records its use
+func AuthenticateAPIKey(key string) (*APIKey, error) {
+	prefix, ok := apiKeyLookupPrefix(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	row, err := tools.FindOneRow("api_key", map[string]string{"prefix": tools.QuoteValue(prefix)})
+	if err == tools.ErrNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := apiKeyFromRow(row)
+	now := time.Now().UTC()
+	if !k.accepts(key, now) {
+		return nil, ErrInvalidAPIKey
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > lastUsedPrecision {
+		//only the date is written : a revocation made meanwhile is kept
+		if _, err := tools.Exec("UPDATE `api_key` SET `last_used_at` = ? WHERE `id` = ?", now, k.ID); err != nil {
+			return nil, err
+		}
+		k.LastUsedAt = &now
+	}
+	return k, nil
+}
+
+//Scope returns the roles the key is restricted to, nil if it is not restricted
+func (k *APIKey) Scope() authz.Scope {
+	if k.RoleIDs == "" {
+		return nil
+	}
+	scope := authz.Scope{}
+	for _, v := range strings.Split(k.RoleIDs, ",") {
+		if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+			scope[id] = true
+		}
+	}
+	return scope
+}
+
+//Revoke disables the key at once
+func (k *APIKey) Revoke() error {
+	if k.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	if _, err := tools.Exec("UPDATE `api_key` SET `revoked_at` = ? WHERE `id` = ? AND `revoked_at` IS NULL", now, k.ID); err != nil {
+		return err
+	}
+	k.RevokedAt = &now
+	return nil
+}
@@ -0,0 +1,79 @@
+package authn
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	k, key := generateAPIKey(&authz.User{ID: 7, DomainID: 3}, " ci ", &expiresAt, "1,2")
+	if k.UserID != 7 || k.DomainID != 3 || k.Name != "ci" || k.RoleIDs != "1,2" || k.ExpiresAt != &expiresAt {
+		t.Errorf("generateAPIKey returned %+v", k)
+	}
+	//only the hash of the key is kept, the prefix identifying its record
+	if k.Hash != tools.HashToken(key) || strings.Contains(k.Hash, key) {
+		t.Errorf("generateAPIKey stored hash %s for key %s", k.Hash, key)
+	}
+	if prefix, ok := apiKeyLookupPrefix(key); !ok || prefix != k.Prefix || !strings.HasPrefix(key, k.Prefix+"_") {
+		t.Errorf("key %s gives prefix %q, %v, want %s", key, prefix, ok, k.Prefix)
+	}
+	if _, other := generateAPIKey(&authz.User{ID: 7, DomainID: 3}, "ci", nil, ""); other == key {
+		t.Error("generateAPIKey drew the same key twice")
+	}
+}
+
+func TestAPIKeyLookupPrefix(t *testing.T) {
+	tests := []struct {
+		key    string
+		prefix string
+		ok     bool
+	}{
+		{"mk_0a1b2c3d_secret", "mk_0a1b2c3d", true},
+		{"mk_0a1b2c3d", "", false},
+		{"mk__secret", "", false},
+		{"mk_0a1b2c3d_", "", false},
+		{"mk_0a1b_2c3d_secret", "", false},
+		{"xx_0a1b2c3d_secret", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		prefix, ok := apiKeyLookupPrefix(test.key)
+		if prefix != test.prefix || ok != test.ok {
+			t.Errorf("apiKeyLookupPrefix(%q) = %q, %v, want %q, %v", test.key, prefix, ok, test.prefix, test.ok)
+		}
+	}
+}
+
+func TestAPIKeyAccepts(t *testing.T) {
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Second), now.Add(time.Hour)
+	k, key := generateAPIKey(&authz.User{ID: 1, DomainID: 1}, "ci", nil, "")
+	if !k.accepts(key, now) {
+		t.Error("key without expiration refused")
+	}
+	if k.accepts(key+"x", now) || k.accepts(k.Prefix+"_"+strings.Repeat("0", 48), now) {
+		t.Error("wrong secret accepted")
+	}
+	k.ExpiresAt = &future
+	if !k.accepts(key, now) {
+		t.Error("key expiring later refused")
+	}
+	k.ExpiresAt = &now
+	if k.accepts(key, now) {
+		t.Error("key accepted at its expiration date")
+	}
+	k.ExpiresAt = &past
+	if k.accepts(key, now) {
+		t.Error("expired key accepted")
+	}
+	k.ExpiresAt = nil
+	k.RevokedAt = &past
+	if k.accepts(key, now) {
+		t.Error("revoked key accepted")
+	}
+}
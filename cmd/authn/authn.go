@@ -0,0 +1,73 @@
+package authn
+
+/**** authn holds the authentication part of mauth
+
+Apps identify their domain with a domain token. A user authenticates with their login and
+password inside a domain and receives :
+- a short lived access token (JWT) carrying the user and domain claims
+- a longer lived refresh token (JWT) used to get a new pair of tokens
+Tokens are signed with a key dedicated to the domain, so a token minted for a domain
+is rejected by any other one.
+
+Domains open to registration let users sign up by themselves. They cannot authenticate
+until they verify their email with the token mailed to them.
+
+Users may add a TOTP second factor, and domains may require one for the holders of a role.
+Such users first get a short lived pending token, exchanged for the session tokens along with
+a code of their authenticator app or a one-time recovery code.
+
+Failed authentications are counted per login and per IP : logins are slowed down by an
+exponential backoff, then logins and IPs are locked for a while after too many failures.
+
+Machines use API keys instead, sent as an "Authorization: ApiKey <key>" header.
+
+*/
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//errors returned by the authn package
+var (
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+	ErrInvalidDomain      = errors.New("Invalid domain token")
+	ErrInvalidToken       = errors.New("Invalid token")
+)
+
+//Authenticate checks the credentials of a user of the domain.
+//Unknown logins and wrong passwords both return ErrInvalidCredentials
+func Authenticate(domain *authz.Domain, login string, password string) (*authz.User, error) {
+	u, err := authz.GetUserByLogin(domain.ID, login)
+	if err == authz.ErrNotFound {
+		//spend the same time as for a known login
+		VerifyPassword(dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	ok, needsRehash, err := VerifyPassword(u.Password, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if u.VerificationPending {
+		//only told once the password is known, so it does not leak which logins exist
+		return nil, ErrUnverifiedUser
+	}
+	if needsRehash {
+		//the password is known right now : upgrade the stored hash to the current parameters
+		if err := SetPassword(u, password); err != nil {
+			fmt.Printf("Unable to rehash the password of user %d : %s\n", u.ID, err.Error())
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,44 @@
+package authn
+
+import (
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//RegisterDomain creates a domain along with its signing key. The returned domain token
+//has to be sent by apps as a header, it is not stored and cannot be read again
+func RegisterDomain(name string) (*authz.Domain, string, error) {
+	d, err := authz.CreateDomain(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := createDomainKey(d.ID); err != nil {
+		return nil, "", err
+	}
+	token, err := RegenerateDomainToken(d)
+	if err != nil {
+		return nil, "", err
+	}
+	return d, token, nil
+}
+
+//RegenerateDomainToken replaces the token of a domain. The previous token stops working at once
+func RegenerateDomainToken(d *authz.Domain) (string, error) {
+	token := tools.RandomHex(32)
+	if err := d.SetTokenHash(tools.HashToken(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+//GetDomainFromToken returns the domain identified by the domain token sent by apps
+func GetDomainFromToken(token string) (*authz.Domain, error) {
+	if token == "" {
+		return nil, ErrInvalidDomain
+	}
+	d, err := authz.GetDomainByTokenHash(tools.HashToken(token))
+	if err == authz.ErrNotFound {
+		return nil, ErrInvalidDomain
+	}
+	return d, err
+}
@@ -0,0 +1,22 @@
+package authn
+
+import (
+	"gitlab.com/hiveway/getaround-api-catch/cmd/dispatcher"
+)
+
+//names of the events published on the authn channel
+const (
+	EventRefreshTokenReused     = "security.refresh_token_reused" //*RefreshToken, the replayed token
+	EventLockout                = "security.lockout"              //*Lockout, a login or an IP was locked after too many failures
+	EventLockoutCleared         = "security.lockout_cleared"      //*Lockout, an admin lifted a lock
+	EventPasswordResetRequested = "password.reset.requested"      //*PasswordReset, a token was mailed
+	EventPasswordResetRejected  = "password.reset.rejected"       //*PasswordReset, a used or expired token was presented
+	EventPasswordResetCompleted = "password.reset.completed"      //*PasswordReset, the password was changed
+)
+
+//Events is the channel on which authentication events are published
+var Events = dispatcher.GetOrCreate("authn")
+
+func publish(eventname string, payload interface{}) {
+	Events.Publish(eventname, payload)
+}
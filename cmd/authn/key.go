@@ -0,0 +1,146 @@
+package authn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcmorel/morm"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//DomainKey is a JWT signing key of a domain. A domain has one active key at a time.
+//Once rotated, a key only verifies tokens until its VerifyUntil date
+type DomainKey struct {
+	ID          uint64     `db:"id" json:"id"`
+	DomainID    uint64     `db:"domain_id" json:"domain_id"`
+	KeyID       string     `db:"key_id" json:"key_id"`
+	Secret      string     `db:"secret" json:"-"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	RetiredAt   *time.Time `db:"retired_at" json:"retired_at,omitempty"`
+	VerifyUntil *time.Time `db:"verify_until" json:"verify_until,omitempty"`
+}
+
+//KeyRotationGrace is how long a rotated key keeps verifying tokens when no cut-off is given
+var KeyRotationGrace = RefreshTokenDuration
+
+//keyCacheDuration is how long a key read from the database is kept in memory
+const keyCacheDuration = time.Minute
+
+type cachedKey struct {
+	key      *DomainKey
+	loadedAt time.Time
+}
+
+var (
+	keyMutex sync.Mutex
+	keyCache = map[string]cachedKey{}
+)
+
+func domainKeyFromRow(row map[string]interface{}) *DomainKey {
+	return &DomainKey{
+		ID:          tools.RowUint(row, "id"),
+		DomainID:    tools.RowUint(row, "domain_id"),
+		KeyID:       morm.SafeString(row["key_id"]),
+		Secret:      morm.SafeString(row["secret"]),
+		CreatedAt:   tools.RowTime(row, "created_at"),
+		RetiredAt:   morm.SafeTime(row["retired_at"]),
+		VerifyUntil: morm.SafeTime(row["verify_until"]),
+	}
+}
+
+//CanVerify tells if the key may still verify tokens at a given time
+func (k *DomainKey) CanVerify(at time.Time) bool {
+	return k.VerifyUntil == nil || at.Before(*k.VerifyUntil)
+}
+
+//createDomainKey generates a new active key for a domain
+func createDomainKey(domainID uint64) (*DomainKey, error) {
+	k := DomainKey{
+		DomainID:  domainID,
+		KeyID:     tools.RandomHex(8),
+		Secret:    tools.RandomHex(32),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := morm.Create(&k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+//getDomainKeys returns every key of a domain, retired ones included
+func getDomainKeys(domainID uint64) ([]*DomainKey, error) {
+	rows, err := tools.FindAllRows("domain_key", map[string]string{"domain_id": tools.FormatID(domainID), "morm_orderby": "created_at desc"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*DomainKey, len(rows))
+	for i, row := range rows {
+		result[i] = domainKeyFromRow(row)
+	}
+	return result, nil
+}
+
+//getActiveKey returns the key used to sign the tokens of a domain, creating it if needed
+func getActiveKey(domainID uint64) (*DomainKey, error) {
+	keys, err := getDomainKeys(domainID)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.RetiredAt == nil {
+			return k, nil
+		}
+	}
+	return createDomainKey(domainID)
+}
+
+//getKeyByID returns a key from its key ID, using the in memory cache
+func getKeyByID(keyID string) (*DomainKey, error) {
+	keyMutex.Lock()
+	cached, ok := keyCache[keyID]
+	keyMutex.Unlock()
+	if ok && time.Since(cached.loadedAt) < keyCacheDuration {
+		return cached.key, nil
+	}
+	row, err := tools.FindOneRow("domain_key", map[string]string{"key_id": tools.QuoteValue(keyID)})
+	if err != nil {
+		return nil, err
+	}
+	k := domainKeyFromRow(row)
+	keyMutex.Lock()
+	keyCache[keyID] = cachedKey{key: k, loadedAt: time.Now()}
+	keyMutex.Unlock()
+	return k, nil
+}
+
+//RotateDomainKey creates a new signing key for a domain. Previous keys keep verifying
+//tokens during the grace duration, a negative grace using KeyRotationGrace
+func RotateDomainKey(domainID uint64, grace time.Duration) (*DomainKey, error) {
+	if grace < 0 {
+		grace = KeyRotationGrace
+	}
+	keys, err := getDomainKeys(domainID)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := createDomainKey(domainID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	cutoff := now.Add(grace)
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+	for _, k := range keys {
+		if k.RetiredAt != nil {
+			continue
+		}
+		k.RetiredAt = &now
+		k.VerifyUntil = &cutoff
+		if err := morm.Update(k); err != nil {
+			return nil, err
+		}
+		delete(keyCache, k.KeyID)
+	}
+	return newKey, nil
+}
@@ -0,0 +1,322 @@
+package authn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marcmorel/morm"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//errors returned by the second factor
+var (
+	ErrMFARequired       = errors.New("Second factor required by a role of the user")
+	ErrMFAAlreadyEnabled = errors.New("Second factor already enabled")
+	ErrMFANotEnrolled    = errors.New("No second factor enrolled")
+	ErrInvalidMFACode    = errors.New("Invalid second factor code")
+)
+
+//TOTP parameters (RFC 6238), the defaults of every authenticator app
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	//totpSkew is the number of periods accepted before and after the current one
+	totpSkew = 1
+)
+
+//RecoveryCodeCount is the number of recovery codes generated when a second factor is confirmed
+const RecoveryCodeCount = 10
+
+//UserMFA is the TOTP second factor of a user. It is pending until confirmed with a first code.
+//LastStep is the last accepted time step, so a code cannot be replayed
+type UserMFA struct {
+	ID          uint64     `db:"id" json:"id"`
+	DomainID    uint64     `db:"domain_id" json:"domain_id"`
+	UserID      uint64     `db:"user_id" json:"user_id"`
+	Secret      string     `db:"secret" json:"-"`
+	LastStep    int64      `db:"last_step" json:"-"`
+	ConfirmedAt *time.Time `db:"confirmed_at" json:"confirmed_at,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+//RecoveryCode is a one-time code replacing the TOTP code when the device is lost.
+//Only its SHA-256 is stored : the codes are random, a slow hash would only let
+//wrong codes exhaust the CPU and the memory of the server
+type RecoveryCode struct {
+	ID        uint64     `db:"id" json:"id"`
+	UserID    uint64     `db:"user_id" json:"user_id"`
+	Hash      string     `db:"hash" json:"-"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+func userMFAFromRow(row map[string]interface{}) *UserMFA {
+	return &UserMFA{
+		ID:          tools.RowUint(row, "id"),
+		DomainID:    tools.RowUint(row, "domain_id"),
+		UserID:      tools.RowUint(row, "user_id"),
+		Secret:      morm.SafeString(row["secret"]),
+		LastStep:    int64(tools.RowUint(row, "last_step")),
+		ConfirmedAt: morm.SafeTime(row["confirmed_at"]),
+		CreatedAt:   tools.RowTime(row, "created_at"),
+	}
+}
+
+func recoveryCodeFromRow(row map[string]interface{}) *RecoveryCode {
+	return &RecoveryCode{
+		ID:        tools.RowUint(row, "id"),
+		UserID:    tools.RowUint(row, "user_id"),
+		Hash:      morm.SafeString(row["hash"]),
+		UsedAt:    morm.SafeTime(row["used_at"]),
+		CreatedAt: tools.RowTime(row, "created_at"),
+	}
+}
+
+//GetUserMFA returns the second factor of a user, confirmed or not, or ErrNotFound
+func GetUserMFA(userID uint64) (*UserMFA, error) {
+	row, err := tools.FindOneRow("user_mfa", map[string]string{"user_id": tools.FormatID(userID)})
+	if err != nil {
+		return nil, err
+	}
+	return userMFAFromRow(row), nil
+}
+
+//MFAEnabled tells if the user has a confirmed second factor
+func MFAEnabled(userID uint64) (bool, error) {
+	m, err := GetUserMFA(userID)
+	if err == tools.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.ConfirmedAt != nil, nil
+}
+
+//totpCode computes the code of a time step (RFC 4226 dynamic truncation)
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+//matchTOTP returns the time step matching the code, or 0 when the code is wrong
+//or belongs to a step already used
+func (m *UserMFA) matchTOTP(code string, now time.Time) int64 {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(m.Secret)
+	if err != nil || len(code) != totpDigits {
+		return 0
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > m.LastStep && subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+//EnrollTOTP generates a new TOTP secret for the user and returns it along with its otpauth:// provisioning URI.
+//The second factor is active once confirmed with ConfirmTOTP. A pending enrollment is replaced
+func EnrollTOTP(u *authz.User) (string, string, error) {
+	m, err := GetUserMFA(u.ID)
+	if err != nil && err != tools.ErrNotFound {
+		return "", "", err
+	}
+	if m != nil && m.ConfirmedAt != nil {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	if m == nil {
+		m = &UserMFA{DomainID: u.DomainID, UserID: u.ID, Secret: secret, CreatedAt: time.Now().UTC()}
+		err = morm.Create(m)
+	} else {
+		m.Secret = secret
+		m.LastStep = 0
+		err = morm.Update(m)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	issuer := "mauth"
+	if d, err := authz.GetDomainByID(u.DomainID); err == nil {
+		issuer = d.Name
+	}
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	uri := "otpauth://totp/" + url.PathEscape(issuer+":"+u.Login) + "?" + values.Encode()
+	return secret, uri, nil
+}
+
+//ConfirmTOTP activates the pending second factor of the user with a first code.
+//The recovery codes are returned once and cannot be read again
+func ConfirmTOTP(u *authz.User, code string) ([]string, error) {
+	m, err := GetUserMFA(u.ID)
+	if err == tools.ErrNotFound {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step := m.matchTOTP(strings.TrimSpace(code), time.Now())
+	if step == 0 {
+		return nil, ErrInvalidMFACode
+	}
+	//the secret is checked too : the code matched it, not the one of an enrollment made meanwhile
+	affected, err := tools.Exec("UPDATE `user_mfa` SET `last_step` = ?, `confirmed_at` = ? "+
+		"WHERE `id` = ? AND `secret` = ? AND `last_step` < ? AND `confirmed_at` IS NULL",
+		step, time.Now().UTC(), m.ID, m.Secret, step)
+	if err != nil {
+		return nil, err
+	}
+	if affected != 1 {
+		return nil, ErrInvalidMFACode
+	}
+	return generateRecoveryCodes(u.ID)
+}
+
+//RegenerateRecoveryCodes replaces the recovery codes of a user with a confirmed second factor
+func RegenerateRecoveryCodes(u *authz.User) ([]string, error) {
+	enabled, err := MFAEnabled(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrMFANotEnrolled
+	}
+	return generateRecoveryCodes(u.ID)
+}
+
+//generateRecoveryCodes deletes the recovery codes of a user and creates new ones
+func generateRecoveryCodes(userID uint64) ([]string, error) {
+	if err := deleteRecoveryCodes(userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		secret := tools.RandomHex(10)
+		codes[i] = secret[:5] + "-" + secret[5:10] + "-" + secret[10:15] + "-" + secret[15:]
+		rc := RecoveryCode{UserID: userID, Hash: recoveryCodeHash(secret), CreatedAt: time.Now().UTC()}
+		if err := morm.Create(&rc); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func deleteRecoveryCodes(userID uint64) error {
+	rows, err := tools.FindAllRows("recovery_code", map[string]string{"user_id": tools.FormatID(userID)})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := morm.Delete(recoveryCodeFromRow(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//recoveryCodeHash returns the stored hash of a recovery code, dashes and case being ignored
+func recoveryCodeHash(code string) string {
+	return tools.HashToken(strings.ToLower(strings.Replace(code, "-", "", -1)))
+}
+
+//useRecoveryCode marks the matching unused recovery code of the user as used
+func useRecoveryCode(userID uint64, code string) (bool, error) {
+	row, err := tools.FindOneRow("recovery_code", map[string]string{
+		"user_id": tools.FormatID(userID), "hash": tools.QuoteValue(recoveryCodeHash(code))})
+	if err == tools.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rc := recoveryCodeFromRow(row)
+	if rc.UsedAt != nil {
+		return false, nil
+	}
+	affected, err := tools.Exec("UPDATE `recovery_code` SET `used_at` = ? WHERE `id` = ? AND `used_at` IS NULL",
+		time.Now().UTC(), rc.ID)
+	return affected == 1, err
+}
+
+//VerifySecondFactor checks a TOTP code or a recovery code of a user with a confirmed second factor
+func VerifySecondFactor(u *authz.User, code string) error {
+	code = strings.TrimSpace(code)
+	m, err := GetUserMFA(u.ID)
+	if err == tools.ErrNotFound || (err == nil && m.ConfirmedAt == nil) {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+	if step := m.matchTOTP(code, time.Now()); step != 0 {
+		//of concurrent uses of the code, possibly on several instances, a single one moves last_step
+		affected, err := tools.Exec("UPDATE `user_mfa` SET `last_step` = ? WHERE `id` = ? AND `last_step` < ?", step, m.ID, step)
+		if err != nil {
+			return err
+		}
+		if affected != 1 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+	ok, err := useRecoveryCode(u.ID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+//DisableTOTP removes the second factor and the recovery codes of a user, a valid code being required.
+//It is refused while a role of the user requires a second factor
+func DisableTOTP(u *authz.User, code string) error {
+	required, err := authz.UserRequiresMFA(u.ID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+	if err := VerifySecondFactor(u, code); err != nil {
+		return err
+	}
+	m, err := GetUserMFA(u.ID)
+	if err != nil {
+		return err
+	}
+	if err := morm.Delete(m); err != nil {
+		return err
+	}
+	return deleteRecoveryCodes(u.ID)
+}
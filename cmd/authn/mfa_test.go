@@ -0,0 +1,104 @@
+package authn
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//rfc6238Secret is the SHA-1 secret of the test vectors of RFC 6238
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	//RFC 6238 appendix B, SHA-1 : the 6 digit codes are the last digits of the 8 digit ones
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		if code := totpCode(rfc6238Secret, test.unix/totpPeriod); code != test.code {
+			t.Errorf("totpCode at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+//newTestMFA returns a second factor using the secret of the RFC 6238 vectors
+func newTestMFA() *UserMFA {
+	return &UserMFA{Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(rfc6238Secret)}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	tests := []struct {
+		step int64
+		ok   bool
+	}{
+		{current, true},
+		{current - totpSkew, true},
+		{current + totpSkew, true},
+		{current - totpSkew - 1, false},
+		{current + totpSkew + 1, false},
+	}
+	for _, test := range tests {
+		m := newTestMFA()
+		code := totpCode(rfc6238Secret, test.step)
+		step := m.matchTOTP(code, now)
+		if (step == test.step) != test.ok || (!test.ok && step != 0) {
+			t.Errorf("code of step %+d matched step %+d, want accepted %v", test.step-current, step-current, test.ok)
+		}
+	}
+	m := newTestMFA()
+	for _, code := range []string{"", "00592", "0059244", "abcdef"} {
+		if step := m.matchTOTP(code, now); step != 0 {
+			t.Errorf("malformed code %q matched step %d", code, step)
+		}
+	}
+	m.Secret = "not base32!"
+	if step := m.matchTOTP(totpCode(rfc6238Secret, current), now); step != 0 {
+		t.Errorf("code matched an undecodable secret at step %d", step)
+	}
+}
+
+func TestMatchTOTPReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	m := newTestMFA()
+	code := totpCode(rfc6238Secret, current)
+	if step := m.matchTOTP(code, now); step != current {
+		t.Fatalf("first use matched step %d, want %d", step, current)
+	}
+	m.LastStep = current
+	if step := m.matchTOTP(code, now); step != 0 {
+		t.Errorf("replayed code matched step %d", step)
+	}
+	//an earlier step of the window is refused too once a later one was used
+	if step := m.matchTOTP(totpCode(rfc6238Secret, current-1), now); step != 0 {
+		t.Errorf("code older than the last used one matched step %d", step)
+	}
+	if step := m.matchTOTP(totpCode(rfc6238Secret, current+1), now); step != current+1 {
+		t.Errorf("code of the next step matched step %d, want %d", step, current+1)
+	}
+}
+
+func TestRecoveryCodeHash(t *testing.T) {
+	hash := recoveryCodeHash("abcde-01234-fghij-56789")
+	if hash != tools.HashToken("abcde01234fghij56789") {
+		t.Errorf("recoveryCodeHash = %s, want the SHA-256 of the code without dashes", hash)
+	}
+	if recoveryCodeHash("ABCDE01234-FGHIJ56789") != hash {
+		t.Error("recoveryCodeHash depends on case or dashes")
+	}
+	if recoveryCodeHash("abcde-01234-fghij-56788") == hash {
+		t.Error("two recovery codes share their hash")
+	}
+}
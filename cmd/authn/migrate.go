@@ -0,0 +1,94 @@
+package authn
+
+import (
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//migrations are played in order and must be idempotent
+var migrations = []string{
+	"CREATE TABLE IF NOT EXISTS `refresh_token` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`token_id` VARCHAR(64) NOT NULL," +
+		"`family_id` VARCHAR(64) NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`replaced_by` VARCHAR(64) NOT NULL DEFAULT ''," +
+		"`expires_at` DATETIME NOT NULL," +
+		"`revoked_at` DATETIME NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `refresh_token_id` (`token_id`)," +
+		"KEY `refresh_token_family` (`family_id`)," +
+		"KEY `refresh_token_user` (`user_id`))",
+	"CREATE TABLE IF NOT EXISTS `domain_key` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`key_id` VARCHAR(32) NOT NULL," +
+		"`secret` VARCHAR(128) NOT NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"`retired_at` DATETIME NULL," +
+		"`verify_until` DATETIME NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `domain_key_id` (`key_id`)," +
+		"KEY `domain_key_domain` (`domain_id`))",
+	"CREATE TABLE IF NOT EXISTS `api_key` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`name` VARCHAR(190) NOT NULL DEFAULT ''," +
+		"`prefix` VARCHAR(32) NOT NULL," +
+		"`hash` VARCHAR(64) NOT NULL," +
+		"`role_ids` TEXT," +
+		"`expires_at` DATETIME NULL," +
+		"`last_used_at` DATETIME NULL," +
+		"`revoked_at` DATETIME NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `api_key_prefix` (`prefix`)," +
+		"KEY `api_key_user` (`user_id`))",
+	"CREATE TABLE IF NOT EXISTS `password_reset` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`token_hash` VARCHAR(64) NOT NULL," +
+		"`expires_at` DATETIME NOT NULL," +
+		"`used_at` DATETIME NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `password_reset_token` (`token_hash`)," +
+		"KEY `password_reset_user` (`user_id`))",
+	"CREATE TABLE IF NOT EXISTS `user_mfa` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`secret` VARCHAR(64) NOT NULL," +
+		"`last_step` BIGINT UNSIGNED NOT NULL DEFAULT 0," +
+		"`confirmed_at` DATETIME NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `user_mfa_user` (`user_id`))",
+	"CREATE TABLE IF NOT EXISTS `recovery_code` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`hash` VARCHAR(64) NOT NULL," +
+		"`used_at` DATETIME NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"KEY `recovery_code_user` (`user_id`, `hash`))",
+}
+
+//MigrateDB creates the authn tables if they do not exist yet
+func MigrateDB(dataSource string) error {
+	return tools.MigrateDB(dataSource, migrations)
+}
+
+//Models returns the list of morm models of the package, to be given to morm.InitModels
+func Models() []interface{} {
+	return []interface{}{
+		&RefreshToken{},
+		&DomainKey{},
+		&APIKey{},
+		&PasswordReset{},
+		&UserMFA{},
+		&RecoveryCode{},
+	}
+}
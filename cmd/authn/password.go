@@ -0,0 +1,154 @@
+package authn
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/**** password hashes
+
+Hashes are stored with their algorithm and parameters :
+- argon2id uses the PHC string format : $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+- bcrypt uses its own format : $2a$10$<salt and hash>
+so that stored hashes can be verified after parameters are upgraded, and rehashed
+with the current parameters on the next successful login.
+*/
+
+//password hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+//Argon2Params are the parameters of argon2id. Memory is in KiB
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+//parameters used for new hashes
+var (
+	PasswordAlgorithm = AlgorithmArgon2id
+	Argon2            = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 2, SaltLen: 16, KeyLen: 32}
+	BcryptCost        = bcrypt.DefaultCost
+)
+
+//minimum salt and key lengths of the argon2id hashes accepted, in bytes
+const (
+	minArgon2SaltLen = 8
+	minArgon2KeyLen  = 16
+)
+
+//ErrUnknownHash is returned for stored hashes whose format or parameters are not supported
+var ErrUnknownHash = errors.New("Unknown password hash format")
+
+//dummyHash is compared against when the login does not exist, so that the response time
+//does not tell whether a login exists
+var dummyHash, _ = HashPassword("mauth dummy password")
+
+//HashPassword returns the hash to be stored for a password, with the current algorithm and parameters
+func HashPassword(password string) (string, error) {
+	switch PasswordAlgorithm {
+	case AlgorithmArgon2id:
+		return hashArgon2id(password, Argon2)
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", fmt.Errorf("unknown password algorithm %s", PasswordAlgorithm)
+}
+
+func hashArgon2id(password string, p Argon2Params) (string, error) {
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+//decodeArgon2id reads the parameters, the salt and the key of an argon2id hash
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	p := Argon2Params{}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return p, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+	//argon2 panics on a zero time or thread count, and an empty key would match any password
+	if p.Time == 0 || p.Threads == 0 || p.SaltLen < minArgon2SaltLen || p.KeyLen < minArgon2KeyLen {
+		return p, nil, nil, ErrUnknownHash
+	}
+	return p, salt, key, nil
+}
+
+//VerifyPassword tells if a password matches a stored hash, and whether the hash should be
+//replaced because it was made with another algorithm or weaker parameters than the current ones
+func VerifyPassword(encoded string, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		computed := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+		return true, PasswordAlgorithm != AlgorithmArgon2id || p != Argon2, nil
+	case strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$"):
+		if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) != nil {
+			return false, false, nil
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, err
+		}
+		return true, PasswordAlgorithm != AlgorithmBcrypt || cost != BcryptCost, nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+//SetPassword hashes and stores the password of a user
+func SetPassword(u *authz.User, password string) error {
+	if password == "" {
+		return errors.New("Password cannot be empty")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return u.Save()
+}
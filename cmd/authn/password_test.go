@@ -0,0 +1,113 @@
+package authn
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//useHashParams sets cheap hashing parameters for the duration of the test
+func useHashParams(t *testing.T, algorithm string) {
+	t.Helper()
+	previousAlgorithm, previousArgon2, previousCost := PasswordAlgorithm, Argon2, BcryptCost
+	PasswordAlgorithm = algorithm
+	Argon2 = Argon2Params{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}
+	BcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { PasswordAlgorithm, Argon2, BcryptCost = previousAlgorithm, previousArgon2, previousCost })
+}
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{AlgorithmArgon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{AlgorithmBcrypt, "$2a$04$"},
+	}
+	for _, test := range tests {
+		useHashParams(t, test.algorithm)
+		hash, err := HashPassword("correct horse")
+		if err != nil {
+			t.Fatalf("%s : HashPassword : %v", test.algorithm, err)
+		}
+		if !strings.HasPrefix(hash, test.prefix) {
+			t.Errorf("%s : hash %s, want prefix %s", test.algorithm, hash, test.prefix)
+		}
+		if other, _ := HashPassword("correct horse"); other == hash {
+			t.Errorf("%s : two hashes of a password share their salt", test.algorithm)
+		}
+		ok, needsRehash, err := VerifyPassword(hash, "correct horse")
+		if !ok || needsRehash || err != nil {
+			t.Errorf("%s : VerifyPassword of the password = %v, %v, %v, want true, false, nil", test.algorithm, ok, needsRehash, err)
+		}
+		ok, needsRehash, err = VerifyPassword(hash, "correct horsE")
+		if ok || needsRehash || err != nil {
+			t.Errorf("%s : VerifyPassword of a wrong password = %v, %v, %v, want false, false, nil", test.algorithm, ok, needsRehash, err)
+		}
+	}
+
+	useHashParams(t, "md5")
+	if _, err := HashPassword("correct horse"); err == nil {
+		t.Error("HashPassword with an unknown algorithm succeeded")
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	useHashParams(t, AlgorithmArgon2id)
+	argon2Hash, _ := HashPassword("correct horse")
+	PasswordAlgorithm = AlgorithmBcrypt
+	bcryptHash, _ := HashPassword("correct horse")
+
+	tests := []struct {
+		name   string
+		update func()
+		hash   string
+		rehash bool
+	}{
+		{"bcrypt hash, bcrypt current", func() {}, bcryptHash, false},
+		{"argon2id hash, bcrypt current", func() {}, argon2Hash, true},
+		{"bcrypt hash, higher cost", func() { BcryptCost = bcrypt.MinCost + 1 }, bcryptHash, true},
+		{"argon2id hash, argon2id current", func() { PasswordAlgorithm = AlgorithmArgon2id }, argon2Hash, false},
+		{"bcrypt hash, argon2id current", func() { PasswordAlgorithm = AlgorithmArgon2id }, bcryptHash, true},
+		{"argon2id hash, more memory", func() { PasswordAlgorithm = AlgorithmArgon2id; Argon2.Memory = 128 }, argon2Hash, true},
+		{"argon2id hash, more passes", func() { PasswordAlgorithm = AlgorithmArgon2id; Argon2.Time = 2 }, argon2Hash, true},
+	}
+	for _, test := range tests {
+		useHashParams(t, AlgorithmBcrypt)
+		test.update()
+		ok, needsRehash, err := VerifyPassword(test.hash, "correct horse")
+		if !ok || needsRehash != test.rehash || err != nil {
+			t.Errorf("%s : VerifyPassword = %v, %v, %v, want true, %v, nil", test.name, ok, needsRehash, err, test.rehash)
+		}
+	}
+}
+
+func TestVerifyPasswordInvalidHash(t *testing.T) {
+	useHashParams(t, AlgorithmArgon2id)
+	salt, key := "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	tests := []string{
+		"",
+		"plain text",
+		"$md5$abc",
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt,
+		"$argon2id$v=18$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$",
+		"$argon2id$v=19$m=64,t=1,p=1$$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$!!$" + key,
+	}
+	for _, hash := range tests {
+		ok, needsRehash, err := VerifyPassword(hash, "")
+		if ok || needsRehash || err != ErrUnknownHash {
+			t.Errorf("VerifyPassword(%q) = %v, %v, %v, want false, false, ErrUnknownHash", hash, ok, needsRehash, err)
+		}
+	}
+	if ok, _, err := VerifyPassword("$2a$04$invalid", "correct horse"); ok || err != nil {
+		t.Errorf("VerifyPassword of a malformed bcrypt hash = %v, %v, want false, nil", ok, err)
+	}
+}
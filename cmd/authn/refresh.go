@@ -0,0 +1,97 @@
+package authn
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marcmorel/morm"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//ErrTokenReused is returned when an already rotated refresh token is presented again.
+//The whole family of the token is revoked
+var ErrTokenReused = errors.New("Refresh token reused")
+
+//RefreshToken is the server side state of a refresh token.
+//Every token obtained by rotation belongs to the family of the token issued at login
+type RefreshToken struct {
+	ID         uint64     `db:"id" json:"id"`
+	TokenID    string     `db:"token_id" json:"token_id"`
+	FamilyID   string     `db:"family_id" json:"family_id"`
+	UserID     uint64     `db:"user_id" json:"user_id"`
+	ReplacedBy string     `db:"replaced_by" json:"replaced_by"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+func refreshTokenFromRow(row map[string]interface{}) *RefreshToken {
+	return &RefreshToken{
+		ID:         tools.RowUint(row, "id"),
+		TokenID:    morm.SafeString(row["token_id"]),
+		FamilyID:   morm.SafeString(row["family_id"]),
+		UserID:     tools.RowUint(row, "user_id"),
+		ReplacedBy: morm.SafeString(row["replaced_by"]),
+		ExpiresAt:  tools.RowTime(row, "expires_at"),
+		RevokedAt:  morm.SafeTime(row["revoked_at"]),
+		CreatedAt:  tools.RowTime(row, "created_at"),
+	}
+}
+
+//storeRefreshToken records a newly signed refresh token
+func storeRefreshToken(claims *Claims, familyID string) error {
+	t := RefreshToken{
+		TokenID:   claims.ID,
+		FamilyID:  familyID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt.Time.UTC(),
+		CreatedAt: time.Now().UTC(),
+	}
+	return morm.Create(&t)
+}
+
+//getRefreshToken returns the stored state of a refresh token or ErrNotFound
+func getRefreshToken(tokenID string) (*RefreshToken, error) {
+	row, err := tools.FindOneRow("refresh_token", map[string]string{"token_id": tools.QuoteValue(tokenID)})
+	if err != nil {
+		return nil, err
+	}
+	return refreshTokenFromRow(row), nil
+}
+
+//rotateRefreshToken marks a token as replaced by newTokenID. The write is conditional : of concurrent
+//rotations of the same token, possibly on several instances, a single one succeeds and the others
+//get false, as for a token revoked meanwhile
+func rotateRefreshToken(tokenID string, newTokenID string) (bool, error) {
+	affected, err := tools.Exec("UPDATE `refresh_token` SET `replaced_by` = ? "+
+		"WHERE `token_id` = ? AND `replaced_by` = '' AND `revoked_at` IS NULL", newTokenID, tokenID)
+	return affected == 1, err
+}
+
+//rejectReusedToken revokes the family of a token presented again after its rotation :
+//either the user or an attacker holds a stolen copy
+func rejectReusedToken(t *RefreshToken) error {
+	if err := RevokeFamily(t.FamilyID); err != nil {
+		return err
+	}
+	publish(EventRefreshTokenReused, t)
+	return ErrTokenReused
+}
+
+//revokeRefreshTokens revokes the tokens whose column holds value. The write is conditional : a token
+//keeps the date of its first revocation. column is one of token_id, family_id or user_id
+func revokeRefreshTokens(column string, value interface{}) error {
+	_, err := tools.Exec("UPDATE `refresh_token` SET `revoked_at` = ? WHERE `"+column+"` = ? AND `revoked_at` IS NULL",
+		time.Now().UTC(), value)
+	return err
+}
+
+//RevokeFamily revokes every refresh token of a family
+func RevokeFamily(familyID string) error {
+	return revokeRefreshTokens("family_id", familyID)
+}
+
+//RevokeUserTokens revokes every refresh token of a user, logging them out of every session
+func RevokeUserTokens(userID uint64) error {
+	return revokeRefreshTokens("user_id", userID)
+}
@@ -0,0 +1,177 @@
+package authn
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/mail"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//errors returned by the registration
+var (
+	ErrRegistrationClosed = errors.New("Registration is closed on this domain")
+	ErrUnverifiedUser     = errors.New("Email not verified yet")
+	ErrInvalidEmail       = errors.New("Invalid email")
+	ErrMailUnavailable    = errors.New("No mail sender configured")
+)
+
+//VerificationTokenDuration is how long a verification token may be used
+var VerificationTokenDuration = 48 * time.Hour
+
+//VerificationURL is the link sent to users, "{token}" being replaced by the verification token.
+//When empty the token is sent alone
+var VerificationURL = ""
+
+//MailSender sends the emails of mauth. While it is nil, registrations, verification emails and
+//password resets fail with ErrMailUnavailable
+var MailSender mail.Sender
+
+//PolicyError lists the rules of the password policy a password breaks
+type PolicyError struct {
+	Rules []string
+}
+
+func (e *PolicyError) Error() string {
+	return "Password must " + strings.Join(e.Rules, ", ")
+}
+
+//CheckPasswordPolicy checks a password against the policy of the domain
+func CheckPasswordPolicy(d *authz.Domain, password string) error {
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	rules := []string{}
+	if len([]rune(password)) < d.PasswordMinLength || password == "" {
+		rules = append(rules, "be at least "+strconv.Itoa(d.PasswordMinLength)+" characters long")
+	}
+	if d.PasswordRequireMixedCase && !(lower && upper) {
+		rules = append(rules, "mix lower and upper case letters")
+	}
+	if d.PasswordRequireDigit && !digit {
+		rules = append(rules, "contain a digit")
+	}
+	if d.PasswordRequireSymbol && !symbol {
+		rules = append(rules, "contain a symbol")
+	}
+	if len(rules) > 0 {
+		return &PolicyError{Rules: rules}
+	}
+	return nil
+}
+
+//Register creates a pending user on a domain open to registration and mails them a verification token.
+//The user cannot authenticate until VerifyEmail is called with this token
+func Register(d *authz.Domain, login string, email string, password string) (*authz.User, error) {
+	if !d.AllowRegistration {
+		return nil, ErrRegistrationClosed
+	}
+	if MailSender == nil {
+		return nil, ErrMailUnavailable
+	}
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Name != "" {
+		return nil, ErrInvalidEmail
+	}
+	if err := CheckPasswordPolicy(d, password); err != nil {
+		return nil, err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	u, err := authz.CreateUser(d.ID, login, address.Address)
+	if err != nil {
+		return nil, err
+	}
+	u.Password = hash
+	u.VerificationPending = true
+	if err := sendVerification(u); err != nil {
+		u.Delete()
+		return nil, err
+	}
+	return u, nil
+}
+
+//ResendVerification sends a new verification token to a pending user, the previous one stops working
+func ResendVerification(d *authz.Domain, login string) error {
+	if MailSender == nil {
+		return ErrMailUnavailable
+	}
+	u, err := authz.GetUserByLogin(d.ID, login)
+	if err != nil {
+		return err
+	}
+	if !u.VerificationPending {
+		return nil
+	}
+	return sendVerification(u)
+}
+
+//sendVerification stores a new verification token for the user and mails it.
+//Tokens look like <user id>.<random>, only their hash is stored
+func sendVerification(u *authz.User) error {
+	secret := tools.RandomHex(32)
+	expiresAt := time.Now().UTC().Add(VerificationTokenDuration)
+	u.VerificationTokenHash = tools.HashToken(secret)
+	u.VerificationExpiresAt = &expiresAt
+	if err := u.Save(); err != nil {
+		return err
+	}
+	token := strconv.FormatUint(u.ID, 10) + "." + secret
+	link := token
+	if VerificationURL != "" {
+		link = strings.Replace(VerificationURL, "{token}", token, -1)
+	}
+	return MailSender.Send(u.Email, "Please verify your email",
+		fmt.Sprintf("Hello %s,\n\nplease confirm your email address before %s :\n%s\n",
+			u.Login, expiresAt.Format("2006-01-02 15:04 UTC"), link))
+}
+
+//VerifyEmail marks the user owning the verification token as verified. Tokens are single use
+func VerifyEmail(d *authz.Domain, token string) (*authz.User, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	u, err := authz.GetUserByID(id)
+	if err == authz.ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.DomainID != d.ID || !u.VerificationPending || u.VerificationTokenHash == "" ||
+		u.VerificationExpiresAt == nil || time.Now().After(*u.VerificationExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(tools.HashToken(parts[1])), []byte(u.VerificationTokenHash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	u.VerificationPending = false
+	u.VerificationTokenHash = ""
+	u.VerificationExpiresAt = nil
+	if err := u.Save(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,152 @@
+package authn
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/marcmorel/morm"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//ResetTokenDuration is how long a password reset token may be used
+var ResetTokenDuration = 30 * time.Minute
+
+//ResetURL is the link sent to users, "{token}" being replaced by the reset token.
+//When empty the token is sent alone
+var ResetURL = ""
+
+//PasswordReset is a password reset request. Only the hash of the token is stored
+type PasswordReset struct {
+	ID        uint64     `db:"id" json:"id"`
+	DomainID  uint64     `db:"domain_id" json:"domain_id"`
+	UserID    uint64     `db:"user_id" json:"user_id"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+func passwordResetFromRow(row map[string]interface{}) *PasswordReset {
+	return &PasswordReset{
+		ID:        tools.RowUint(row, "id"),
+		DomainID:  tools.RowUint(row, "domain_id"),
+		UserID:    tools.RowUint(row, "user_id"),
+		TokenHash: morm.SafeString(row["token_hash"]),
+		ExpiresAt: tools.RowTime(row, "expires_at"),
+		UsedAt:    morm.SafeTime(row["used_at"]),
+		CreatedAt: tools.RowTime(row, "created_at"),
+	}
+}
+
+//RequestPasswordReset mails a reset token to the user of the domain with this login.
+//Tokens requested before stop working. Unknown logins return authz.ErrNotFound
+func RequestPasswordReset(d *authz.Domain, login string) error {
+	if MailSender == nil {
+		return ErrMailUnavailable
+	}
+	u, err := authz.GetUserByLogin(d.ID, login)
+	if err != nil {
+		return err
+	}
+	token := tools.RandomHex(32)
+	reset := PasswordReset{
+		DomainID:  d.ID,
+		UserID:    u.ID,
+		TokenHash: tools.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(ResetTokenDuration),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := morm.Create(&reset); err != nil {
+		return err
+	}
+	if err := cancelPasswordResets(u.ID, reset.ID); err != nil {
+		return err
+	}
+	link := token
+	if ResetURL != "" {
+		link = strings.Replace(ResetURL, "{token}", token, -1)
+	}
+	if err := MailSender.Send(u.Email, "Reset your password",
+		fmt.Sprintf("Hello %s,\n\nyou may choose a new password before %s :\n%s\n\nIgnore this email if you did not ask for it.\n",
+			u.Login, reset.ExpiresAt.Format("2006-01-02 15:04 UTC"), link)); err != nil {
+		return err
+	}
+	publish(EventPasswordResetRequested, &reset)
+	return nil
+}
+
+//cancelPasswordResets marks the pending resets of a user created before the reset beforeID as used.
+//Of concurrent requests, the last one created is the only one left usable
+func cancelPasswordResets(userID uint64, beforeID uint64) error {
+	_, err := tools.Exec("UPDATE `password_reset` SET `used_at` = ? WHERE `user_id` = ? AND `id` < ? AND `used_at` IS NULL",
+		time.Now().UTC(), userID, beforeID)
+	return err
+}
+
+//claimPasswordReset marks a reset as used. The write is conditional : of concurrent uses of the
+//same token, possibly on several instances, a single one gets true
+func claimPasswordReset(reset *PasswordReset) (bool, error) {
+	now := time.Now().UTC()
+	affected, err := tools.Exec("UPDATE `password_reset` SET `used_at` = ? WHERE `id` = ? AND `used_at` IS NULL", now, reset.ID)
+	if err != nil || affected != 1 {
+		return false, err
+	}
+	reset.UsedAt = &now
+	return true, nil
+}
+
+//ResetPassword replaces the password of the user owning the reset token and revokes all their
+//refresh tokens. Tokens are single use, unknown, used and expired tokens return ErrInvalidToken
+func ResetPassword(d *authz.Domain, token string, password string) (*authz.User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	row, err := tools.FindOneRow("password_reset", map[string]string{"token_hash": tools.QuoteValue(tools.HashToken(token))})
+	if err == tools.ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	reset := passwordResetFromRow(row)
+	if reset.DomainID != d.ID {
+		return nil, ErrInvalidToken
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		publish(EventPasswordResetRejected, reset)
+		return nil, ErrInvalidToken
+	}
+	if err := CheckPasswordPolicy(d, password); err != nil {
+		return nil, err
+	}
+	u, err := authz.GetUserByID(reset.UserID)
+	if err == authz.ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := claimPasswordReset(reset)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		//used meanwhile
+		publish(EventPasswordResetRejected, reset)
+		return nil, ErrInvalidToken
+	}
+	//the token was received by email, which proves the address as well as a verification would
+	u.VerificationPending = false
+	u.VerificationTokenHash = ""
+	u.VerificationExpiresAt = nil
+	if err := SetPassword(u, password); err != nil {
+		return nil, err
+	}
+	if err := RevokeUserTokens(u.ID); err != nil {
+		return nil, err
+	}
+	publish(EventPasswordResetCompleted, reset)
+	return u, nil
+}
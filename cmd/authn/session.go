@@ -0,0 +1,129 @@
+package authn
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//pending token types, returned instead of a session when a second factor is needed
+const (
+	TokenMFA       = "mfa"        //the user has to send a code of their second factor
+	TokenMFAEnroll = "mfa_enroll" //a role requires a second factor the user has not enrolled yet
+)
+
+//MFAPendingDuration is the lifetime of pending tokens
+var MFAPendingDuration = 5 * time.Minute
+
+//MaxMFAAttempts is the number of wrong codes accepted for a pending token
+var MaxMFAAttempts = 5
+
+//Session is the outcome of a successful password authentication : either a pair of tokens,
+//or a pending token of type MFA to be completed by a second step
+type Session struct {
+	Tokens    *TokenPair `json:"tokens,omitempty"`
+	MFA       string     `json:"mfa,omitempty"`
+	MFAToken  string     `json:"mfa_token,omitempty"`
+	ExpiresIn int        `json:"expires_in,omitempty"`
+}
+
+type mfaAttempt struct {
+	count     int
+	expiresAt time.Time
+}
+
+var (
+	attemptMutex sync.Mutex
+	mfaAttempts  = map[string]*mfaAttempt{}
+)
+
+//StartSession returns the session of a user whose password was checked. Users with a second factor,
+//or holding a role requiring one, get a pending token instead of a pair of tokens
+func StartSession(u *authz.User) (*Session, error) {
+	enabled, err := MFAEnabled(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	pending := ""
+	if enabled {
+		pending = TokenMFA
+	} else {
+		required, err := authz.UserRequiresMFA(u.ID)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			pending = TokenMFAEnroll
+		}
+	}
+	if pending == "" {
+		tokens, err := IssueTokens(u)
+		if err != nil {
+			return nil, err
+		}
+		return &Session{Tokens: tokens}, nil
+	}
+	token, _, err := signToken(u, pending, MFAPendingDuration)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{MFA: pending, MFAToken: token, ExpiresIn: int(MFAPendingDuration / time.Second)}, nil
+}
+
+//CompleteMFA checks the code sent with a pending token of type TokenMFA and returns a pair of tokens.
+//A pending token is rejected after MaxMFAAttempts wrong codes
+func CompleteMFA(domain *authz.Domain, mfaToken string, code string) (*TokenPair, error) {
+	claims, err := ParseToken(domain, mfaToken, TokenMFA)
+	if err != nil {
+		return nil, err
+	}
+	if !allowMFAAttempt(claims) {
+		return nil, ErrInvalidToken
+	}
+	u, err := authz.GetUserByID(claims.UserID)
+	if err == authz.ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	//wrong codes count as failures of the login, so new pending tokens do not give new attempts
+	if err := CheckThrottle(u.DomainID, u.Login, ""); err != nil {
+		return nil, err
+	}
+	if err := VerifySecondFactor(u, code); err != nil {
+		if err == ErrInvalidMFACode {
+			failMFAAttempt(claims)
+			RecordFailure(u.DomainID, u.Login, "")
+		}
+		return nil, err
+	}
+	RecordSuccess(u.DomainID, u.Login)
+	return IssueTokens(u)
+}
+
+//allowMFAAttempt tells if a pending token may still be used. Expired counters are dropped on the way
+func allowMFAAttempt(claims *Claims) bool {
+	attemptMutex.Lock()
+	defer attemptMutex.Unlock()
+	now := time.Now()
+	for id, a := range mfaAttempts {
+		if now.After(a.expiresAt) {
+			delete(mfaAttempts, id)
+		}
+	}
+	a, ok := mfaAttempts[claims.ID]
+	return !ok || a.count < MaxMFAAttempts
+}
+
+func failMFAAttempt(claims *Claims) {
+	attemptMutex.Lock()
+	defer attemptMutex.Unlock()
+	a, ok := mfaAttempts[claims.ID]
+	if !ok {
+		a = &mfaAttempt{expiresAt: claims.ExpiresAt.Time}
+		mfaAttempts[claims.ID] = a
+	}
+	a.count++
+}
@@ -0,0 +1,199 @@
+package authn
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//throttling of password authentications. Failures are counted per login and per IP, in memory.
+//Logins get an exponential backoff then a temporary lock, IPs only get the lock since
+//many users may share an address
+var (
+	LoginLockThreshold = 10               //failures locking a login
+	IPLockThreshold    = 100              //failures locking an IP
+	LockDuration       = 15 * time.Minute //duration of a lock
+	BackoffStart       = 3                //failures of a login before the backoff starts
+	BackoffBase        = time.Second      //first backoff delay, doubled on each failure
+	BackoffMax         = time.Minute      //longest backoff delay
+	FailureWindow      = time.Hour        //counters are forgotten after this time without failure
+)
+
+//kinds of throttled keys
+const (
+	ThrottleLogin = "login"
+	ThrottleIP    = "ip"
+)
+
+//ThrottleError is returned while authentications are refused for a login or an IP
+type ThrottleError struct {
+	Kind       string
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	if e.Locked {
+		return "Too many failed attempts, locked for " + e.RetryAfter.String()
+	}
+	return "Too many failed attempts, retry in " + e.RetryAfter.String()
+}
+
+//Lockout is the payload of the lock events
+type Lockout struct {
+	Kind     string    `json:"kind"`
+	DomainID uint64    `json:"domain_id,omitempty"`
+	Login    string    `json:"login,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+type failures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+var (
+	throttleMutex sync.Mutex
+	loginFailures = map[string]*failures{}
+	ipFailures    = map[string]*failures{}
+	lastPrune     time.Time
+)
+
+//loginKey returns the key of the counter of a login. Logins are normalized so that variants
+//of case or spacing do not get their own attempts
+func loginKey(domainID uint64, login string) string {
+	return strconv.FormatUint(domainID, 10) + ":" + strings.ToLower(strings.TrimSpace(login))
+}
+
+//current returns the counter of a key, forgetting it when its window or its lock is over
+func current(m map[string]*failures, key string, now time.Time) *failures {
+	f, ok := m[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(f.last) > FailureWindow || (f.locked && !now.Before(f.blockedUntil)) {
+		delete(m, key)
+		return nil
+	}
+	return f
+}
+
+//CheckThrottle returns a ThrottleError when authentications of the login or from the IP are refused for now
+func CheckThrottle(domainID uint64, login string, ip string) error {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	now := time.Now()
+	if f := current(ipFailures, ip, now); f != nil && now.Before(f.blockedUntil) {
+		return &ThrottleError{Kind: ThrottleIP, Locked: f.locked, RetryAfter: f.blockedUntil.Sub(now)}
+	}
+	if f := current(loginFailures, loginKey(domainID, login), now); f != nil && now.Before(f.blockedUntil) {
+		return &ThrottleError{Kind: ThrottleLogin, Locked: f.locked, RetryAfter: f.blockedUntil.Sub(now)}
+	}
+	return nil
+}
+
+//RecordFailure counts a failed authentication. Reaching a threshold locks the login or the IP
+//and publishes EventLockout
+func RecordFailure(domainID uint64, login string, ip string) {
+	//events are published once the mutex is released : subscribers may call back the throttle
+	for _, lockout := range recordFailure(domainID, login, ip) {
+		publish(EventLockout, lockout)
+	}
+}
+
+//recordFailure counts a failed authentication and returns the locks it triggered
+func recordFailure(domainID uint64, login string, ip string) []*Lockout {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	var lockouts []*Lockout
+	now := time.Now()
+	prune(now)
+
+	f := current(loginFailures, loginKey(domainID, login), now)
+	if f == nil {
+		f = &failures{}
+		loginFailures[loginKey(domainID, login)] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= LoginLockThreshold {
+		f.locked = true
+		f.blockedUntil = now.Add(LockDuration)
+		lockouts = append(lockouts, &Lockout{Kind: ThrottleLogin, DomainID: domainID, Login: login, Failures: f.count, Until: f.blockedUntil})
+	} else if f.count >= BackoffStart {
+		delay := BackoffBase << uint(f.count-BackoffStart)
+		if delay > BackoffMax || delay <= 0 {
+			delay = BackoffMax
+		}
+		f.blockedUntil = now.Add(delay)
+	}
+
+	if ip == "" {
+		return lockouts
+	}
+	f = current(ipFailures, ip, now)
+	if f == nil {
+		f = &failures{}
+		ipFailures[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= IPLockThreshold && !f.locked {
+		f.locked = true
+		f.blockedUntil = now.Add(LockDuration)
+		lockouts = append(lockouts, &Lockout{Kind: ThrottleIP, IP: ip, Failures: f.count, Until: f.blockedUntil})
+	}
+	return lockouts
+}
+
+//RecordSuccess forgets the failures of a login after a successful authentication
+func RecordSuccess(domainID uint64, login string) {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	delete(loginFailures, loginKey(domainID, login))
+}
+
+//Unlock forgets the failures of a login, lifting its lock. It tells if the login was throttled
+func Unlock(domainID uint64, login string) bool {
+	throttleMutex.Lock()
+	key := loginKey(domainID, login)
+	_, ok := loginFailures[key]
+	delete(loginFailures, key)
+	throttleMutex.Unlock()
+	if !ok {
+		return false
+	}
+	publish(EventLockoutCleared, &Lockout{Kind: ThrottleLogin, DomainID: domainID, Login: login, Until: time.Now()})
+	return true
+}
+
+//UnlockIP forgets the failures of an IP, lifting its lock. It tells if the IP was throttled
+func UnlockIP(ip string) bool {
+	throttleMutex.Lock()
+	_, ok := ipFailures[ip]
+	delete(ipFailures, ip)
+	throttleMutex.Unlock()
+	if !ok {
+		return false
+	}
+	publish(EventLockoutCleared, &Lockout{Kind: ThrottleIP, IP: ip, Until: time.Now()})
+	return true
+}
+
+//prune drops the counters which are over, at most once a minute
+func prune(now time.Time) {
+	if now.Sub(lastPrune) < time.Minute {
+		return
+	}
+	lastPrune = now
+	for _, m := range []map[string]*failures{loginFailures, ipFailures} {
+		for key := range m {
+			current(m, key, now)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package authn
+
+import (
+	"testing"
+	"time"
+)
+
+//useTestThrottle starts the test with empty counters and small thresholds, restored at the end
+func useTestThrottle(t *testing.T) {
+	t.Helper()
+	throttleMutex.Lock()
+	previousLogins, previousIPs := loginFailures, ipFailures
+	loginFailures, ipFailures = map[string]*failures{}, map[string]*failures{}
+	throttleMutex.Unlock()
+	previous := []int{LoginLockThreshold, IPLockThreshold, BackoffStart}
+	previousDurations := []time.Duration{LockDuration, BackoffBase, BackoffMax, FailureWindow}
+	LoginLockThreshold, IPLockThreshold, BackoffStart = 6, 8, 3
+	LockDuration, BackoffBase, BackoffMax, FailureWindow = time.Hour, time.Second, 3*time.Second, 2*time.Hour
+	t.Cleanup(func() {
+		throttleMutex.Lock()
+		loginFailures, ipFailures = previousLogins, previousIPs
+		throttleMutex.Unlock()
+		LoginLockThreshold, IPLockThreshold, BackoffStart = previous[0], previous[1], previous[2]
+		LockDuration, BackoffBase, BackoffMax, FailureWindow = previousDurations[0], previousDurations[1], previousDurations[2], previousDurations[3]
+	})
+}
+
+//checkThrottled fails the test unless the login is throttled as expected. retryAfter is the expected
+//delay, 0 when the login should not be throttled
+func checkThrottled(t *testing.T, login string, ip string, kind string, locked bool, retryAfter time.Duration) {
+	t.Helper()
+	err := CheckThrottle(1, login, ip)
+	if retryAfter == 0 {
+		if err != nil {
+			t.Errorf("CheckThrottle(%s, %s) = %v, want no throttle", login, ip, err)
+		}
+		return
+	}
+	te, ok := err.(*ThrottleError)
+	if !ok {
+		t.Errorf("CheckThrottle(%s, %s) = %v, want a ThrottleError", login, ip, err)
+		return
+	}
+	//the delay runs from the last failure, a few instants ago
+	if te.Kind != kind || te.Locked != locked || te.RetryAfter > retryAfter || te.RetryAfter < retryAfter-time.Second/2 {
+		t.Errorf("CheckThrottle(%s, %s) = %+v, want kind %s, locked %v, retry after %v", login, ip, te, kind, locked, retryAfter)
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	useTestThrottle(t)
+	delays := []time.Duration{0, 0, time.Second, 2 * time.Second, 3 * time.Second}
+	for i, delay := range delays {
+		RecordFailure(1, "bob", "")
+		if delay == 0 {
+			checkThrottled(t, "bob", "", "", false, 0)
+		} else {
+			checkThrottled(t, "bob", "", ThrottleLogin, false, delay)
+		}
+		if t.Failed() {
+			t.Fatalf("after %d failures", i+1)
+		}
+	}
+	//other logins of the domain, and the same login on other domains, are not throttled
+	checkThrottled(t, "alice", "", "", false, 0)
+	if err := CheckThrottle(2, "bob", ""); err != nil {
+		t.Errorf("CheckThrottle on another domain = %v", err)
+	}
+	RecordSuccess(1, "bob")
+	checkThrottled(t, "bob", "", "", false, 0)
+}
+
+func TestThrottleLoginNormalized(t *testing.T) {
+	useTestThrottle(t)
+	for _, login := range []string{"bob", "Bob", " BOB "} {
+		RecordFailure(1, login, "")
+	}
+	checkThrottled(t, "bOb", "", ThrottleLogin, false, time.Second)
+	RecordSuccess(1, "BOB")
+	checkThrottled(t, "bob", "", "", false, 0)
+}
+
+func TestThrottleLockAndUnlock(t *testing.T) {
+	useTestThrottle(t)
+	lockouts := make(chan *Lockout, 10)
+	s := Events.SubscribeExact(EventLockout, func(eventname string, payload interface{}) {
+		lockouts <- payload.(*Lockout)
+	})
+	defer s.Unsubscribe()
+
+	for i := 0; i < LoginLockThreshold; i++ {
+		RecordFailure(1, "bob", "")
+	}
+	checkThrottled(t, "bob", "", ThrottleLogin, true, LockDuration)
+	select {
+	case l := <-lockouts:
+		if l.Kind != ThrottleLogin || l.DomainID != 1 || l.Login != "bob" || l.Failures != LoginLockThreshold {
+			t.Errorf("lockout event %+v", l)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no lockout event")
+	}
+	if !Unlock(1, " Bob") {
+		t.Error("Unlock of a locked login returned false")
+	}
+	checkThrottled(t, "bob", "", "", false, 0)
+	if Unlock(1, "bob") {
+		t.Error("Unlock of an unlocked login returned true")
+	}
+}
+
+func TestThrottleIP(t *testing.T) {
+	useTestThrottle(t)
+	//many logins from the same address, none of them reaching the backoff
+	for i := 0; i < IPLockThreshold; i++ {
+		RecordFailure(1, string(rune('a'+i)), "203.0.113.9")
+	}
+	checkThrottled(t, "zed", "203.0.113.9", ThrottleIP, true, LockDuration)
+	checkThrottled(t, "zed", "203.0.113.10", "", false, 0)
+	if !UnlockIP("203.0.113.9") {
+		t.Error("UnlockIP of a locked address returned false")
+	}
+	checkThrottled(t, "zed", "203.0.113.9", "", false, 0)
+	if UnlockIP("203.0.113.9") {
+		t.Error("UnlockIP of an unlocked address returned true")
+	}
+}
+
+func TestThrottleExpiry(t *testing.T) {
+	useTestThrottle(t)
+	for i := 0; i < LoginLockThreshold; i++ {
+		RecordFailure(1, "bob", "")
+	}
+	for i := 0; i < BackoffStart; i++ {
+		RecordFailure(1, "alice", "")
+	}
+	throttleMutex.Lock()
+	//the lock of bob is over, the last failure of alice is out of the window
+	loginFailures[loginKey(1, "bob")].blockedUntil = time.Now().Add(-time.Second)
+	alice := loginFailures[loginKey(1, "alice")]
+	alice.last = time.Now().Add(-FailureWindow - time.Second)
+	alice.blockedUntil = time.Now().Add(time.Minute)
+	throttleMutex.Unlock()
+
+	checkThrottled(t, "bob", "", "", false, 0)
+	checkThrottled(t, "alice", "", "", false, 0)
+	//their counters start over
+	RecordFailure(1, "bob", "")
+	RecordFailure(1, "alice", "")
+	checkThrottled(t, "bob", "", "", false, 0)
+	checkThrottled(t, "alice", "", "", false, 0)
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	if n := loginFailures[loginKey(1, "bob")].count; n != 1 {
+		t.Errorf("counter after an expired lock = %d, want 1", n)
+	}
+}
@@ -0,0 +1,161 @@
+package authn
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//token types, stored in the typ claim
+const (
+	TokenAccess  = "access"
+	TokenRefresh = "refresh"
+)
+
+//lifetime of the tokens
+var (
+	AccessTokenDuration  = 15 * time.Minute
+	RefreshTokenDuration = 30 * 24 * time.Hour
+)
+
+//Claims are the claims of the tokens minted by mauth
+type Claims struct {
+	UserID   uint64 `json:"uid"`
+	DomainID uint64 `json:"did"`
+	Type     string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+//TokenPair is returned on a successful authentication or refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+//signToken creates a token of the given type for a user, signed with the active key of their domain
+func signToken(u *authz.User, tokenType string, duration time.Duration) (string, *Claims, error) {
+	key, err := getActiveKey(u.DomainID)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := Claims{
+		UserID:   u.ID,
+		DomainID: u.DomainID,
+		Type:     tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tools.RandomHex(16),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+			Issuer:    "mauth",
+		},
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	t.Header["kid"] = key.KeyID
+	token, err := t.SignedString([]byte(key.Secret))
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &claims, nil
+}
+
+//IssueTokens mints a new access token and a new refresh token for a user, starting a new
+//refresh token family
+func IssueTokens(u *authz.User) (*TokenPair, error) {
+	pair, _, err := issueTokens(u, tools.RandomHex(16))
+	return pair, err
+}
+
+//issueTokens mints a pair of tokens, the refresh token being stored in the given family.
+//The claims of the refresh token are returned too
+func issueTokens(u *authz.User, familyID string) (*TokenPair, *Claims, error) {
+	access, _, err := signToken(u, TokenAccess, AccessTokenDuration)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, claims, err := signToken(u, TokenRefresh, RefreshTokenDuration)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := storeRefreshToken(claims, familyID); err != nil {
+		return nil, nil, err
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: int(AccessTokenDuration / time.Second)}, claims, nil
+}
+
+//ParseToken checks the signature, the expiration, the domain and the type of a token and returns its claims.
+//The token has to be signed by a key of the domain which is still allowed to verify tokens
+func ParseToken(domain *authz.Domain, token string, tokenType string) (*Claims, error) {
+	claims := Claims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		keyID, _ := t.Header["kid"].(string)
+		key, err := getKeyByID(keyID)
+		if err != nil || key.DomainID != domain.ID || !key.CanVerify(time.Now()) {
+			return nil, ErrInvalidToken
+		}
+		return []byte(key.Secret), nil
+	})
+	if err != nil || claims.Type != tokenType || claims.DomainID != domain.ID {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+//Refresh checks a refresh token and returns a new pair of tokens in the same family.
+//The presented token can not be used anymore : presenting it again revokes the whole family
+func Refresh(domain *authz.Domain, refreshToken string) (*TokenPair, error) {
+	claims, err := ParseToken(domain, refreshToken, TokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := getRefreshToken(claims.ID)
+	if err == tools.ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.ReplacedBy != "" {
+		return nil, rejectReusedToken(stored)
+	}
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidToken
+	}
+
+	u, err := authz.GetUserByID(claims.UserID)
+	if err == authz.ErrNotFound || (err == nil && u.DomainID != claims.DomainID) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	pair, newClaims, err := issueTokens(u, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := rotateRefreshToken(stored.TokenID, newClaims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		//rotated or revoked since it was read : the new token is withdrawn
+		if err := revokeRefreshTokens("token_id", newClaims.ID); err != nil {
+			return nil, err
+		}
+		if stored, err = getRefreshToken(stored.TokenID); err != nil {
+			return nil, err
+		}
+		if stored.ReplacedBy != "" {
+			return nil, rejectReusedToken(stored)
+		}
+		return nil, ErrInvalidToken
+	}
+	return pair, nil
+}
@@ -0,0 +1,50 @@
+package authz
+
+/**** authz holds the authorization model of mauth
+
+Every object of the model belongs to a Domain : users, actions and roles are unique
+among their domain but the same login or role name may exist in two different domains.
+
+- Action is an atomic task whose access has to be verified (carOpen)
+- Role gathers Actions (driver = carOpen + carStart). One action may belong to multiple roles
+- Right assigns a Role to a User on a specific object_id, with an optional expiration date.
+  Expired rights are ignored by checks and archived by SweepExpiredRights
+
+object_id is not managed by mauth, it is a string provided by the calling app.
+
+The model is persisted by a Store : SQL through morm, or a JSON flat file when
+DATASOURCE is file:///path/to/file.json
+
+*/
+
+import (
+	"errors"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//errors returned by the authz package
+var (
+	ErrNotFound       = tools.ErrNotFound
+	ErrAlreadyExists  = errors.New("Already exists")
+	ErrDomainMismatch = errors.New("Objects belong to different domains")
+	ErrEmptyName      = errors.New("Name cannot be empty")
+	ErrRoleCycle      = errors.New("Role would include itself")
+)
+
+//Models returns the list of morm models of the package, to be given to morm.InitModels
+func Models() []interface{} {
+	return []interface{}{
+		&Domain{},
+		&User{},
+		&Action{},
+		&Role{},
+		&RoleAction{},
+		&RoleInclude{},
+		&Right{},
+		&RightArchive{},
+		&Group{},
+		&GroupMember{},
+		&GroupAudit{},
+	}
+}
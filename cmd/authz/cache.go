@@ -0,0 +1,185 @@
+package authz
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Cache keeps the resolved rights of the most recent users in memory.
+//Entries expire after a TTL, the least recently used ones are evicted when the cache is full
+//and every modification invalidates the entries it affects before returning.
+//Only the modifications made by this process are seen : when several instances share the
+//storage, the modifications made by the others are picked up once the entries expire, so the
+//TTL bounds how long a revoked right may still be granted
+type Cache struct {
+	mutex      sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[uint64]*list.Element
+	lru        *list.List
+	generation uint64 //incremented on every invalidation, to drop snapshots loaded meanwhile
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+}
+
+//CacheStats exposes the counters of the cache
+type CacheStats struct {
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"max_entries"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+type cacheEntry struct {
+	userID   uint64
+	snap     *snapshot
+	loadedAt time.Time
+}
+
+//cache is used by the checks once EnableCache has been called
+var cache *Cache
+
+//NewCache creates a cache. maxEntries <= 0 means no size limit
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[uint64]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+//EnableCache creates the per user cache used by the checks, replacing a previously enabled cache
+func EnableCache(ttl time.Duration, maxEntries int) *Cache {
+	cache = NewCache(ttl, maxEntries)
+	return cache
+}
+
+//DisableCache stops caching the rights of the users
+func DisableCache() {
+	cache = nil
+}
+
+//GetCache returns the cache used by the checks, nil if disabled
+func GetCache() *Cache {
+	return cache
+}
+
+//get returns the snapshot of a user, loading it on a miss
+func (c *Cache) get(userID uint64) (*snapshot, error) {
+	c.mutex.Lock()
+	if elt, ok := c.entries[userID]; ok {
+		entry := elt.Value.(*cacheEntry)
+		if time.Since(entry.loadedAt) < c.ttl {
+			c.hits++
+			c.lru.MoveToFront(elt)
+			c.mutex.Unlock()
+			return entry.snap, nil
+		}
+		c.remove(elt)
+	}
+	c.misses++
+	generation := c.generation
+	c.mutex.Unlock()
+
+	snap, err := loadSnapshot(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		//the model changed while loading, do not keep a possibly stale snapshot
+		return snap, nil
+	}
+	if elt, ok := c.entries[userID]; ok {
+		c.remove(elt)
+	}
+	c.entries[userID] = c.lru.PushFront(&cacheEntry{userID: userID, snap: snap, loadedAt: time.Now()})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+	return snap, nil
+}
+
+//remove deletes an entry. mutex must be held
+func (c *Cache) remove(elt *list.Element) {
+	delete(c.entries, elt.Value.(*cacheEntry).userID)
+	c.lru.Remove(elt)
+}
+
+//Invalidate drops the cached rights of a user
+func (c *Cache) Invalidate(userID uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	if elt, ok := c.entries[userID]; ok {
+		c.remove(elt)
+	}
+}
+
+//Purge drops every entry of the cache
+func (c *Cache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.entries = make(map[uint64]*list.Element)
+	c.lru.Init()
+}
+
+//Stats returns the counters of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{
+		Entries:    c.lru.Len(),
+		MaxEntries: c.maxEntries,
+		TTLSeconds: int(c.ttl / time.Second),
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+	}
+}
+
+//invalidateEvent drops the entries affected by the modification described by an event
+func (c *Cache) invalidateEvent(eventname string, payload interface{}) {
+	if eventname == EventRightExpiring {
+		//the right is still valid
+		return
+	}
+	switch p := payload.(type) {
+	case *Right:
+		if p.GroupID != 0 {
+			//the right of a group is shared by its members
+			c.Purge()
+			return
+		}
+		c.Invalidate(p.UserID)
+	case *User:
+		c.Invalidate(p.ID)
+	case *GroupMember:
+		c.Invalidate(p.UserID)
+	case *Group:
+		c.Purge()
+	default:
+		//roles and actions are shared by many users
+		if strings.HasPrefix(eventname, "role.") || strings.HasPrefix(eventname, "action.") {
+			c.Purge()
+		}
+	}
+}
+
+//getSnapshot returns the snapshot of a user, from the cache if enabled
+func getSnapshot(userID uint64) (*snapshot, error) {
+	if cache == nil {
+		return loadSnapshot(userID)
+	}
+	return cache.get(userID)
+}
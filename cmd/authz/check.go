@@ -0,0 +1,268 @@
+package authz
+
+import (
+	"strings"
+	"time"
+)
+
+//Decision is the result of an authorization check
+type Decision struct {
+	Allowed       bool     `json:"allowed"`
+	UserID        uint64   `json:"user_id"`
+	Action        string   `json:"action"`
+	Object        string   `json:"object"`
+	MatchedObject string   `json:"matched_object,omitempty"`
+	Right         *Right   `json:"right,omitempty"`
+	RolePath      []string `json:"role_path,omitempty"`
+	Group         string   `json:"group,omitempty"`
+	Reason        string   `json:"reason"`
+}
+
+//CheckItem is one (action, object path) pair of a batch check, with optional attributes
+//completing the ones of the batch
+type CheckItem struct {
+	Action     string     `json:"action"`
+	Object     string     `json:"object"`
+	Attributes Attributes `json:"attributes,omitempty"`
+}
+
+//Scope restricts a check to a subset of the roles of the user, by role ID.
+//A nil Scope allows every role
+type Scope map[uint64]bool
+
+//allows tells if a role may be used by a check
+func (sc Scope) allows(roleID uint64) bool {
+	return sc == nil || sc[roleID]
+}
+
+//snapshot holds everything needed to check the rights of a user, loaded once.
+//It is shared through the cache and must never be modified once loaded
+type snapshot struct {
+	user        *User
+	actions     map[string]bool                //names of the actions of the domain
+	rights      []*Right                       //rights of the user and of their groups
+	groupNames  map[uint64]string              //names of the groups holding some of the rights
+	rightPaths  []ObjectPath                   //parsed object_id of each right
+	rightIndex  map[string][]int               //indexes of the rights by the last segment of their path
+	conditions  []*Condition                   //parsed condition of each right, nil when unconditional
+	roleActions map[uint64]map[string][]string //actions of each granted role, with their inheritance path
+}
+
+//loadSnapshot reads the rights of a user and the actions of the roles they are given
+func loadSnapshot(userID uint64) (*snapshot, error) {
+	u, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{user: u, actions: map[string]bool{}, roleActions: map[uint64]map[string][]string{},
+		rightIndex: map[string][]int{}, groupNames: map[uint64]string{}}
+
+	actions, err := GetActions(u.DomainID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		s.actions[a.Name] = true
+	}
+
+	if s.rights, err = getHolderRights(u.ID); err != nil {
+		return nil, err
+	}
+	s.rightPaths = make([]ObjectPath, len(s.rights))
+	s.conditions = make([]*Condition, len(s.rights))
+	for i, r := range s.rights {
+		s.rightPaths[i], _ = ParseObjectPath(r.ObjectID)
+		if r.Condition != "" {
+			if s.conditions[i], err = ParseCondition(r.Condition); err != nil {
+				//conditions are checked when granted, but the limits may have changed since or the
+				//storage been edited : an invalid one never holds and reports why
+				s.conditions[i] = invalidCondition(r.Condition, err)
+			}
+		}
+		if r.GroupID != 0 {
+			if g, err := GetGroupByID(r.GroupID); err == nil {
+				s.groupNames[r.GroupID] = g.Name
+			}
+		}
+		if len(s.rightPaths[i]) > 0 {
+			key := s.rightPaths[i][len(s.rightPaths[i])-1].String()
+			s.rightIndex[key] = append(s.rightIndex[key], i)
+		}
+		if _, ok := s.roleActions[r.RoleID]; ok {
+			continue
+		}
+		if s.roleActions[r.RoleID], err = resolveRoleActions(r.RoleID); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+//check walks the object path from the object up to the root, looking for a valid right whose role
+//contains the action. Deny rights are looked for first on the whole path : a deny on the object or
+//any of its ancestors beats every grant, wherever it is. Then the deepest granted right whose role
+//is in the scope allows the action.
+//A granted right whose condition does not hold for attrs is skipped, and reported when no other right applies
+func (s *snapshot) check(objectID string, action string, scope Scope, attrs Attributes, now time.Time) *Decision {
+	decision := &Decision{UserID: s.user.ID, Action: action, Object: objectID}
+	path, err := ParseCheckedPath(objectID)
+	if err != nil {
+		decision.Reason = err.Error()
+		return decision
+	}
+	decision.Object = path.String()
+	if !s.actions[action] {
+		decision.Reason = "unknown action"
+		return decision
+	}
+	unmet := ""
+	for _, deny := range []bool{true, false} {
+		for depth := len(path); depth > 0; depth-- {
+			ancestor := path[:depth]
+			for _, i := range s.candidates(ancestor[depth-1]) {
+				r := s.rights[i]
+				//a scope only narrows what is granted, deny rights always apply
+				if r.Deny != deny || r.Expired(now) || (!deny && !scope.allows(r.RoleID)) || !ancestor.EndsWith(s.rightPaths[i]) {
+					continue
+				}
+				rolePath, ok := s.roleActions[r.RoleID][action]
+				if !ok {
+					continue
+				}
+				if c := s.conditions[i]; c != nil && !deny {
+					if holds, err := c.Eval(attrs); err != nil || !holds {
+						if unmet == "" {
+							unmet = "condition of right on " + r.ObjectID + " does not hold"
+							if err != nil {
+								unmet = "condition of right on " + r.ObjectID + " failed : " + err.Error()
+							}
+						}
+						continue
+					}
+				}
+				decision.Allowed = !deny
+				decision.Right = r
+				decision.RolePath = rolePath
+				decision.MatchedObject = ancestor.String()
+				decision.Reason = "granted by right on "
+				if deny {
+					decision.Reason = "denied by right on "
+				}
+				decision.Reason += r.ObjectID + " with role " + strings.Join(rolePath, " > ")
+				if r.GroupID != 0 {
+					decision.Group = s.groupNames[r.GroupID]
+					decision.Reason += " of group " + decision.Group
+				}
+				if s.conditions[i] != nil && !deny {
+					decision.Reason += " under condition " + r.Condition
+				}
+				return decision
+			}
+		}
+	}
+	decision.Reason = "no right grants this action on the object or its ancestors"
+	if unmet != "" {
+		decision.Reason = unmet
+	}
+	return decision
+}
+
+//candidates returns the indexes of the rights whose path ends with the segment or with
+//the wildcard of its type, the only ones which may apply to a path ending with the segment
+func (s *snapshot) candidates(last Segment) []int {
+	exact := s.rightIndex[last.String()]
+	pattern := s.rightIndex[last.Pattern().String()]
+	if len(pattern) == 0 {
+		return exact
+	}
+	return append(append(make([]int, 0, len(exact)+len(pattern)), exact...), pattern...)
+}
+
+//Check tells if a user may trigger an action on an object.
+//objectID is the whole tree of the object, for example company(9)garage(28)car(123).
+//The action is allowed if a valid right of the user on the object or any of its ancestors
+//gives a role of the scope containing the action, directly or through the roles it includes,
+//unless a deny right on the object or any of its ancestors withdraws it, whatever the depth of the grant.
+//attrs describe the context of the check for the conditions of the rights, and may be nil.
+//The deepest matching right is reported in the decision, along with the inheritance path of roles.
+//A *PathError is returned for an invalid path, or a path holding a wildcard id.
+func Check(userID uint64, objectID string, action string, scope Scope, attrs Attributes) (*Decision, error) {
+	if _, err := ParseCheckedPath(objectID); err != nil {
+		return nil, err
+	}
+	s, err := getSnapshot(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return s.check(objectID, action, scope, completeAttributes(attrs, nil, now), now), nil
+}
+
+//CheckBatch runs many checks for a user against a single snapshot of their rights.
+//The attributes of each item complete attrs, the ones of the whole batch.
+//An invalid object path denies its own item without failing the whole batch, but a wildcard id
+//in any item fails it with a *PathError : it asks for many objects at once, which checks cannot answer.
+func CheckBatch(userID uint64, items []CheckItem, scope Scope, attrs Attributes) ([]*Decision, error) {
+	for _, item := range items {
+		if _, err := ParseCheckedPath(item.Object); isWildcardError(err) {
+			return nil, err
+		}
+	}
+	s, err := getSnapshot(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := make([]*Decision, len(items))
+	for i, item := range items {
+		result[i] = s.check(item.Object, item.Action, scope, completeAttributes(attrs, item.Attributes, now), now)
+	}
+	return result, nil
+}
+
+//completeAttributes merges the attributes of a check, the ones of item winning,
+//and sets the time attribute to now when it is not given
+func completeAttributes(attrs Attributes, item Attributes, now time.Time) Attributes {
+	result := Attributes{AttributeTime: now.UTC().Format(time.RFC3339)}
+	for k, v := range attrs {
+		result[k] = v
+	}
+	for k, v := range item {
+		result[k] = v
+	}
+	return result
+}
+
+//resolveRoleActions returns the actions of a role and of the roles it includes. Each action comes
+//with the inheritance path of role names giving it, the shortest one when several roles give it
+func resolveRoleActions(roleID uint64) (map[string][]string, error) {
+	result := map[string][]string{}
+	paths := map[uint64][]string{}
+	roles, err := flattenRole(roleID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		//roles come breadth first : the path of the including role is known
+		path := append(append([]string{}, paths[role.ID]...), role.Name)
+		actions, err := role.GetActions()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range actions {
+			if _, ok := result[a.Name]; !ok {
+				result[a.Name] = path
+			}
+		}
+		includes, err := store.GetRoleIncludes(role.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ri := range includes {
+			if _, ok := paths[ri.IncludedRoleID]; !ok {
+				paths[ri.IncludedRoleID] = path
+			}
+		}
+	}
+	return result, nil
+}
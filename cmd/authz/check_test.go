@@ -0,0 +1,179 @@
+package authz
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//useTestStore plugs a flat file store in a temporary directory for the duration of the test
+func useTestStore(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "authz.json")
+	s, err := NewStore("file://" + path)
+	if err != nil {
+		t.Fatalf("NewStore : %v", err)
+	}
+	previous := store
+	SetStore(s)
+	t.Cleanup(func() { store = previous })
+	return path
+}
+
+//testModel is a domain with a user and a maintainer role giving carOpen
+type testModel struct {
+	domain     *Domain
+	user       *User
+	maintainer *Role
+}
+
+func newTestModel(t *testing.T) *testModel {
+	t.Helper()
+	m := &testModel{}
+	var err error
+	if m.domain, err = CreateDomain("getaround"); err != nil {
+		t.Fatalf("CreateDomain : %v", err)
+	}
+	if m.user, err = CreateUser(m.domain.ID, "bob", ""); err != nil {
+		t.Fatalf("CreateUser : %v", err)
+	}
+	if m.maintainer, err = CreateRole(m.domain.ID, "maintainer", ""); err != nil {
+		t.Fatalf("CreateRole : %v", err)
+	}
+	open, err := CreateAction(m.domain.ID, "carOpen", "")
+	if err != nil {
+		t.Fatalf("CreateAction : %v", err)
+	}
+	if err := m.maintainer.AddAction(open); err != nil {
+		t.Fatalf("AddAction : %v", err)
+	}
+	return m
+}
+
+func TestCheckDenyPrecedence(t *testing.T) {
+	useTestStore(t)
+	m := newTestModel(t)
+	for _, r := range []struct {
+		deny   bool
+		object string
+	}{
+		{false, "garage(28)"},
+		{true, "garage(28)car(123)"},
+		{false, "car(123)door(1)"},
+		{true, "garage(29)"},
+		{false, "company(9)garage(29)car(7)"},
+	} {
+		grant := GrantRight
+		if r.deny {
+			grant = DenyRight
+		}
+		if _, err := grant(m.user.ID, m.maintainer.ID, r.object, nil); err != nil {
+			t.Fatalf("grant on %s : %v", r.object, err)
+		}
+	}
+
+	tests := []struct {
+		object  string
+		allowed bool
+		matched string
+	}{
+		{"company(9)garage(28)car(124)", true, "company(9)garage(28)"},
+		{"company(9)garage(28)car(123)", false, "company(9)garage(28)car(123)"},
+		{"company(9)garage(28)car(123)wheel(2)", false, "company(9)garage(28)car(123)"},
+		//a grant on a descendant of a denied object does not beat the deny
+		{"company(9)garage(28)car(123)door(1)", false, "company(9)garage(28)car(123)"},
+		{"company(9)garage(29)car(7)", false, "company(9)garage(29)"},
+		{"company(9)garage(30)", false, ""},
+	}
+	for _, test := range tests {
+		d, err := Check(m.user.ID, test.object, "carOpen", nil, nil)
+		if err != nil {
+			t.Fatalf("Check(%s) : %v", test.object, err)
+		}
+		if d.Allowed != test.allowed || d.MatchedObject != test.matched {
+			t.Errorf("Check(%s) = %v on %q (%s), want %v on %q", test.object, d.Allowed, d.MatchedObject, d.Reason, test.allowed, test.matched)
+		}
+	}
+
+	//a scope narrows the grants only
+	d, err := Check(m.user.ID, "garage(28)car(123)", "carOpen", Scope{m.maintainer.ID + 1: true}, nil)
+	if err != nil || d.Allowed || d.Right == nil || !d.Right.Deny {
+		t.Errorf("scoped Check = %+v, %v, want denied by the deny right", d, err)
+	}
+}
+
+func TestUserRequiresMFAIgnoresDeny(t *testing.T) {
+	useTestStore(t)
+	m := newTestModel(t)
+	if err := m.maintainer.SetRequireMFA(true); err != nil {
+		t.Fatalf("SetRequireMFA : %v", err)
+	}
+	if _, err := DenyRight(m.user.ID, m.maintainer.ID, "garage(28)", nil); err != nil {
+		t.Fatalf("DenyRight : %v", err)
+	}
+	if required, err := UserRequiresMFA(m.user.ID); err != nil || required {
+		t.Errorf("UserRequiresMFA with a deny right = %v, %v, want false", required, err)
+	}
+	if _, err := GrantRight(m.user.ID, m.maintainer.ID, "garage(29)", nil); err != nil {
+		t.Fatalf("GrantRight : %v", err)
+	}
+	if required, err := UserRequiresMFA(m.user.ID); err != nil || !required {
+		t.Errorf("UserRequiresMFA with a granted right = %v, %v, want true", required, err)
+	}
+}
+
+func TestCacheInvalidatedSynchronously(t *testing.T) {
+	useTestStore(t)
+	m := newTestModel(t)
+	c := EnableCache(time.Hour, 10)
+	defer DisableCache()
+	check := func(want bool) {
+		t.Helper()
+		d, err := Check(m.user.ID, "garage(28)", "carOpen", nil, nil)
+		if err != nil || d.Allowed != want {
+			t.Fatalf("Check = %+v, %v, want allowed %v", d, err, want)
+		}
+	}
+	check(false)
+	right, err := GrantRight(m.user.ID, m.maintainer.ID, "garage(28)", nil)
+	if err != nil {
+		t.Fatalf("GrantRight : %v", err)
+	}
+	//no wait : the grant has invalidated the cached rights before returning
+	check(true)
+	check(true)
+	if err := right.Revoke(); err != nil {
+		t.Fatalf("Revoke : %v", err)
+	}
+	check(false)
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("cache counted %d hits and %d misses, want 1 and 3", stats.Hits, stats.Misses)
+	}
+}
+
+func TestCheckRejectsWildcards(t *testing.T) {
+	useTestStore(t)
+	m := newTestModel(t)
+	if _, err := GrantRight(m.user.ID, m.maintainer.ID, "garage(*)", nil); err != nil {
+		t.Fatalf("GrantRight on a wildcard : %v", err)
+	}
+	d, err := Check(m.user.ID, "garage(28)car(123)", "carOpen", nil, nil)
+	if err != nil || !d.Allowed {
+		t.Errorf("Check under a wildcard right = %+v, %v, want allowed", d, err)
+	}
+	_, err = Check(m.user.ID, "garage(28)car(*)", "carOpen", nil, nil)
+	checkPathError(t, "Check of a wildcard", err, 14, wildcardMsg)
+	//an escaped star is a plain id
+	if _, err := Check(m.user.ID, `garage(28)car(\*)`, "carOpen", nil, nil); err != nil {
+		t.Errorf("Check of an escaped star : %v", err)
+	}
+
+	items := []CheckItem{{Object: "garage(28)", Action: "carOpen"}, {Object: "garage(", Action: "carOpen"}}
+	decisions, err := CheckBatch(m.user.ID, items, nil, nil)
+	if err != nil || len(decisions) != 2 || !decisions[0].Allowed || decisions[1].Allowed {
+		t.Errorf("CheckBatch with a malformed path = %v, %v, want only the malformed item denied", decisions, err)
+	}
+	items = append(items, CheckItem{Object: "garage(*)", Action: "carOpen"})
+	_, err = CheckBatch(m.user.ID, items, nil, nil)
+	checkPathError(t, "CheckBatch of a wildcard", err, 7, wildcardMsg)
+}
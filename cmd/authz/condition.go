@@ -0,0 +1,527 @@
+package authz
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**** conditions
+
+A right may carry a condition, restricting it to some contexts. The condition is evaluated at check
+time against the attributes supplied with the request, and the right only grants its actions when
+the condition holds :
+	time >= rental_start && time < rental_end && ip in ["10.0.0.0/8", "192.168.1.0/24"]
+
+Operands are attributes (names made of letters, digits, '_', '.' and '-'), "quoted strings",
+numbers, true and false, or lists of these between brackets.
+Operators, from the loosest to the tightest : ||, &&, !, then the comparisons ==, !=, <, <=, >, >=
+and in. Parenthesis group sub expressions.
+Values are compared as numbers when both sides are numbers, as dates when both sides are RFC3339
+dates and as strings otherwise, strings having no order. Comparing NaN or an infinite number is
+an error, and "x in list" never matches them. "x in list" is true when x equals an
+element of the list, or belongs to it when the element is a CIDR range and x an IP address.
+The time attribute defaults to the time of the check and the ip attribute is the address of the
+end user, supplied by the caller like any other attribute.
+
+Evaluation only reads the attributes, has no loop nor any access to the clock or the network, and
+the size of a condition is bounded : it always ends, with the same result for the same attributes.
+An unknown attribute or a type mismatch is an error, and the right does not apply.
+*/
+
+//Attributes are the key/values describing the context of a check, read by the conditions of the rights
+type Attributes map[string]string
+
+//attributes always known to the conditions
+const (
+	AttributeTime = "time"
+	AttributeIP   = "ip"
+)
+
+//MaxConditionLength is the maximal length of a condition, in bytes
+var MaxConditionLength = 1024
+
+//maxConditionDepth limits the nesting of parenthesis, negations and lists in a condition
+const maxConditionDepth = 32
+
+//ConditionError describes why a condition could not be parsed or evaluated
+type ConditionError struct {
+	Condition string
+	Pos       int
+	Msg       string
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("condition %q at position %d : %s", e.Condition, e.Pos, e.Msg)
+}
+
+//Condition is a parsed condition, safe for concurrent evaluations
+type Condition struct {
+	source string
+	root   *condNode
+	err    error //why the source could not be parsed, for the conditions of invalidCondition
+}
+
+//condNode is a node of the syntax tree of a condition
+type condNode struct {
+	op    string //"str", "bool", "attr", "list", "!", "&&", "||", "in" or a comparison
+	pos   int
+	value string
+	args  []*condNode
+}
+
+//condValue is the result of the evaluation of a node : a string, a boolean or a list of strings
+type condValue struct {
+	isBool bool
+	isList bool
+	b      bool
+	s      string
+	list   []string
+}
+
+//ParseCondition parses a condition
+func ParseCondition(s string) (*Condition, error) {
+	if len(s) > MaxConditionLength {
+		return nil, &ConditionError{Condition: s, Pos: MaxConditionLength, Msg: "condition too long"}
+	}
+	tokens, err := tokenizeCondition(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &condParser{source: s, tokens: tokens}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.fail(t, "unexpected "+t.text)
+	}
+	return &Condition{source: s, root: root}, nil
+}
+
+//invalidCondition returns a condition which never holds, Eval returning the parse error
+func invalidCondition(source string, err error) *Condition {
+	return &Condition{source: source, err: err}
+}
+
+//String returns the source of the condition
+func (c *Condition) String() string {
+	return c.source
+}
+
+//Eval tells if the condition holds for the attributes
+func (c *Condition) Eval(attrs Attributes) (bool, error) {
+	if c.root == nil {
+		if c.err != nil {
+			return false, c.err
+		}
+		return false, &ConditionError{Condition: c.source, Msg: "invalid condition"}
+	}
+	v, err := c.eval(c.root, attrs)
+	if err != nil {
+		return false, err
+	}
+	if !v.isBool {
+		return false, c.fail(c.root, "condition is not a boolean")
+	}
+	return v.b, nil
+}
+
+func (c *Condition) fail(n *condNode, msg string) error {
+	return &ConditionError{Condition: c.source, Pos: n.pos, Msg: msg}
+}
+
+func (c *Condition) eval(n *condNode, attrs Attributes) (condValue, error) {
+	switch n.op {
+	case "str":
+		return condValue{s: n.value}, nil
+	case "bool":
+		return condValue{isBool: true, b: n.value == "true"}, nil
+	case "attr":
+		v, ok := attrs[n.value]
+		if !ok {
+			return condValue{}, c.fail(n, "unknown attribute "+n.value)
+		}
+		return condValue{s: v}, nil
+	case "list":
+		v := condValue{isList: true}
+		for _, arg := range n.args {
+			elem, err := c.eval(arg, attrs)
+			if err != nil {
+				return condValue{}, err
+			}
+			v.list = append(v.list, elem.String())
+		}
+		return v, nil
+	case "!":
+		v, err := c.evalBool(n.args[0], attrs)
+		return condValue{isBool: true, b: !v}, err
+	case "&&", "||":
+		//evaluation is short-circuited : the right side may rely on the left one
+		left, err := c.evalBool(n.args[0], attrs)
+		if err != nil || left == (n.op == "||") {
+			return condValue{isBool: true, b: left}, err
+		}
+		right, err := c.evalBool(n.args[1], attrs)
+		return condValue{isBool: true, b: right}, err
+	}
+
+	left, err := c.eval(n.args[0], attrs)
+	if err != nil {
+		return condValue{}, err
+	}
+	right, err := c.eval(n.args[1], attrs)
+	if err != nil {
+		return condValue{}, err
+	}
+	if left.isList {
+		return condValue{}, c.fail(n, "a list cannot be compared")
+	}
+	if n.op == "in" {
+		list := right.list
+		if !right.isList {
+			list = []string{right.String()}
+		}
+		for _, elem := range list {
+			if inCondition(left.String(), elem) {
+				return condValue{isBool: true, b: true}, nil
+			}
+		}
+		return condValue{isBool: true}, nil
+	}
+	if right.isList {
+		return condValue{}, c.fail(n, "a list cannot be compared")
+	}
+	cmp, ordered, valid := compareCondition(left.String(), right.String())
+	if !valid {
+		return condValue{}, c.fail(n, fmt.Sprintf("%q and %q cannot be compared, NaN and infinite numbers are refused", left.String(), right.String()))
+	}
+	if !ordered && n.op != "==" && n.op != "!=" {
+		return condValue{}, c.fail(n, fmt.Sprintf("%q and %q cannot be ordered", left.String(), right.String()))
+	}
+	result := false
+	switch n.op {
+	case "==":
+		result = cmp == 0
+	case "!=":
+		result = cmp != 0
+	case "<":
+		result = cmp < 0
+	case "<=":
+		result = cmp <= 0
+	case ">":
+		result = cmp > 0
+	case ">=":
+		result = cmp >= 0
+	}
+	return condValue{isBool: true, b: result}, nil
+}
+
+func (c *Condition) evalBool(n *condNode, attrs Attributes) (bool, error) {
+	v, err := c.eval(n, attrs)
+	if err != nil {
+		return false, err
+	}
+	if !v.isBool {
+		return false, c.fail(n, "boolean expected")
+	}
+	return v.b, nil
+}
+
+//String returns the value as compared with other values
+func (v condValue) String() string {
+	if v.isBool {
+		return strconv.FormatBool(v.b)
+	}
+	return v.s
+}
+
+//compareCondition compares two values as numbers, as dates, or as strings. ordered is false
+//when the values are compared as strings, only their equality being meaningful. valid is false
+//when a number is NaN or infinite : NaN equals nothing and infinities would pass any bound, so
+//the comparison fails rather than yield a result
+func compareCondition(a string, b string) (cmp int, ordered bool, valid bool) {
+	if fa, err := strconv.ParseFloat(a, 64); err == nil {
+		if fb, err := strconv.ParseFloat(b, 64); err == nil {
+			if !finiteNumber(fa) || !finiteNumber(fb) {
+				return 0, false, false
+			}
+			switch {
+			case fa < fb:
+				return -1, true, true
+			case fa > fb:
+				return 1, true, true
+			}
+			return 0, true, true
+		}
+	}
+	if ta, err := time.Parse(time.RFC3339, a); err == nil {
+		if tb, err := time.Parse(time.RFC3339, b); err == nil {
+			switch {
+			case ta.Before(tb):
+				return -1, true, true
+			case ta.After(tb):
+				return 1, true, true
+			}
+			return 0, true, true
+		}
+	}
+	if a == b {
+		return 0, false, true
+	}
+	return 1, false, true
+}
+
+//finiteNumber tells if a number is neither NaN nor infinite
+func finiteNumber(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+//inCondition tells if a value equals an element of a list, or belongs to it for a CIDR range
+func inCondition(value string, elem string) bool {
+	if strings.Contains(elem, "/") {
+		if _, network, err := net.ParseCIDR(elem); err == nil {
+			ip := net.ParseIP(value)
+			return ip != nil && network.Contains(ip)
+		}
+	}
+	cmp, _, valid := compareCondition(value, elem)
+	return valid && cmp == 0
+}
+
+//kinds of the tokens of a condition
+const (
+	tokenEnd = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type condToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+//tokenizeCondition splits a condition into tokens
+func tokenizeCondition(s string) ([]condToken, error) {
+	tokens := []condToken{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			var b strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, &ConditionError{Condition: s, Pos: start, Msg: "unterminated string"}
+				}
+				if s[i] == '"' {
+					break
+				}
+				if s[i] == '\\' {
+					if i+1 >= len(s) {
+						return nil, &ConditionError{Condition: s, Pos: i, Msg: "dangling escape character"}
+					}
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			i++
+			tokens = append(tokens, condToken{kind: tokenString, text: b.String(), pos: start})
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			for i++; i < len(s) && (s[i] == '.' || (s[i] >= '0' && s[i] <= '9')); i++ {
+			}
+			if _, err := strconv.ParseFloat(s[start:i], 64); err != nil {
+				return nil, &ConditionError{Condition: s, Pos: start, Msg: "invalid number " + s[start:i]}
+			}
+			tokens = append(tokens, condToken{kind: tokenNumber, text: s[start:i], pos: start})
+		case isIdentChar(c):
+			start := i
+			for ; i < len(s) && (isIdentChar(s[i]) || s[i] == '-'); i++ {
+			}
+			tokens = append(tokens, condToken{kind: tokenIdent, text: s[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &ConditionError{Condition: s, Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, condToken{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, condToken{kind: tokenEnd, text: "end of condition", pos: len(s)}), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+//comparisonOperators are the binary operators between two operands, along with in
+var comparisonOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+//condParser is a recursive descent parser of conditions
+type condParser struct {
+	source string
+	tokens []condToken
+	next   int
+}
+
+func (p *condParser) peek() condToken {
+	return p.tokens[p.next]
+}
+
+func (p *condParser) fail(t condToken, msg string) error {
+	return &ConditionError{Condition: p.source, Pos: t.pos, Msg: msg}
+}
+
+//accept consumes the next token if it is the operator op
+func (p *condParser) accept(op string) (condToken, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator || t.text != op {
+		return t, false
+	}
+	p.next++
+	return t, true
+}
+
+func (p *condParser) expect(op string) error {
+	if t, ok := p.accept(op); !ok {
+		return p.fail(t, "'"+op+"' expected instead of "+t.text)
+	}
+	return nil
+}
+
+func (p *condParser) parseOr(depth int) (*condNode, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept("||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &condNode{op: "||", pos: t.pos, args: []*condNode{left, right}}
+	}
+}
+
+func (p *condParser) parseAnd(depth int) (*condNode, error) {
+	left, err := p.parseNot(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept("&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &condNode{op: "&&", pos: t.pos, args: []*condNode{left, right}}
+	}
+}
+
+func (p *condParser) parseNot(depth int) (*condNode, error) {
+	t, ok := p.accept("!")
+	if !ok {
+		return p.parseComparison(depth)
+	}
+	if depth >= maxConditionDepth {
+		return nil, p.fail(t, "condition too deep")
+	}
+	arg, err := p.parseNot(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{op: "!", pos: t.pos, args: []*condNode{arg}}, nil
+}
+
+func (p *condParser) parseComparison(depth int) (*condNode, error) {
+	left, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if !(t.kind == tokenIdent && t.text == "in") && !(t.kind == tokenOperator && comparisonOperators[t.text]) {
+		return left, nil
+	}
+	p.next++
+	right, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{op: t.text, pos: t.pos, args: []*condNode{left, right}}, nil
+}
+
+func (p *condParser) parseOperand(depth int) (*condNode, error) {
+	t := p.peek()
+	if depth >= maxConditionDepth {
+		return nil, p.fail(t, "condition too deep")
+	}
+	p.next++
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return &condNode{op: "str", pos: t.pos, value: t.text}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &condNode{op: "bool", pos: t.pos, value: t.text}, nil
+		case "in":
+			return nil, p.fail(t, "unexpected in")
+		}
+		return &condNode{op: "attr", pos: t.pos, value: t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			n := &condNode{op: "list", pos: t.pos}
+			if _, ok := p.accept("]"); ok {
+				return n, nil
+			}
+			for {
+				elem := p.peek()
+				if elem.kind != tokenString && elem.kind != tokenNumber && elem.kind != tokenIdent {
+					return nil, p.fail(elem, "list elements must be values or attributes")
+				}
+				arg, err := p.parseOperand(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				n.args = append(n.args, arg)
+				if _, ok := p.accept("]"); ok {
+					return n, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	p.next--
+	return nil, p.fail(t, "unexpected "+t.text)
+}
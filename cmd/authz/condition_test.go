@@ -0,0 +1,268 @@
+package authz
+
+import (
+	"strings"
+	"testing"
+)
+
+var testAttributes = Attributes{
+	"time":         "2024-05-01T10:00:00Z",
+	"rental_start": "2024-05-01T09:00:00+01:00",
+	"rental_end":   "2024-05-02T00:00:00Z",
+	"ip":           "10.1.2.3",
+	"ipv6":         "2001:db8::1",
+	"count":        "10",
+	"flag":         "true",
+	"name":         "bob",
+	"nan":          "NaN",
+	"inf":          "+Inf",
+}
+
+func TestConditionEval(t *testing.T) {
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		//literals and attributes
+		{`true`, true},
+		{`false`, false},
+		{`flag == true`, true},
+		{`name == "bob"`, true},
+		{`name != "bob"`, false},
+
+		//precedence : ! binds tighter than &&, which binds tighter than ||
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`false && true || true`, true},
+		{`!true || true`, true},
+		{`!!true`, true},
+
+		//short-circuit : the unknown attribute is never read
+		{`true || missing == "x"`, true},
+		{`false && missing == "x"`, false},
+
+		//numbers
+		{`count == 10`, true},
+		{`count == 10.0`, true},
+		{`count > 9.5`, true},
+		{`count >= 10`, true},
+		{`count < 2`, false},
+		{`count <= -1`, false},
+		{`count != 11`, true},
+
+		//dates, compared as instants whatever their time zone
+		{`time >= rental_start && time < rental_end`, true},
+		{`time > rental_end`, false},
+		{`rental_start == "2024-05-01T08:00:00Z"`, true},
+
+		//strings only support equality
+		{`name == "alice"`, false},
+		{`"a\"b" == "a\"b"`, true},
+
+		//in
+		{`ip in ["192.168.0.0/16", "10.0.0.0/8"]`, true},
+		{`ip in "10.1.0.0/16"`, true},
+		{`ip in "192.168.0.0/16"`, false},
+		{`ipv6 in "2001:db8::/32"`, true},
+		{`name in "10.0.0.0/8"`, false},
+		{`name in ["alice", "bob"]`, true},
+		{`count in [1, 10.0]`, true},
+		{`name in [count, ip]`, false},
+		{`name in []`, false},
+		{`nan in ["NaN", 1]`, false},
+		{`inf in [inf]`, false},
+		{`name != nan`, true},
+		{`ip in ["not/a cidr", "10.1.2.3"]`, true},
+	}
+	for _, test := range tests {
+		c, err := ParseCondition(test.condition)
+		if err != nil {
+			t.Errorf("ParseCondition(%s) : unexpected error %v", test.condition, err)
+			continue
+		}
+		got, err := c.Eval(testAttributes)
+		if err != nil {
+			t.Errorf("Eval(%s) : unexpected error %v", test.condition, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Eval(%s) = %v, want %v", test.condition, got, test.want)
+		}
+	}
+}
+
+func TestConditionEvalErrors(t *testing.T) {
+	tests := []struct {
+		condition string
+		pos       int
+		msg       string
+	}{
+		{`missing == "x"`, 0, "unknown attribute missing"},
+		{`missing == "x" || true`, 0, "unknown attribute missing"},
+		{`true && missing`, 8, "unknown attribute missing"},
+		{`name`, 0, "condition is not a boolean"},
+		{`count && true`, 0, "boolean expected"},
+		{`!name`, 1, "boolean expected"},
+		{`name < "x"`, 5, "cannot be ordered"},
+		{`time < 2`, 5, "cannot be ordered"},
+		{`[1] == 1`, 4, "a list cannot be compared"},
+		{`1 == [1]`, 2, "a list cannot be compared"},
+		{`[1] in [1]`, 4, "a list cannot be compared"},
+		{`ip in [missing]`, 7, "unknown attribute missing"},
+		{`nan == nan`, 4, "NaN and infinite numbers are refused"},
+		{`nan != 1`, 4, "NaN and infinite numbers are refused"},
+		{`inf > 1`, 4, "NaN and infinite numbers are refused"},
+		{`count < inf`, 6, "NaN and infinite numbers are refused"},
+	}
+	for _, test := range tests {
+		c, err := ParseCondition(test.condition)
+		if err != nil {
+			t.Errorf("ParseCondition(%s) : unexpected error %v", test.condition, err)
+			continue
+		}
+		got, err := c.Eval(testAttributes)
+		checkConditionError(t, test.condition, err, test.pos, test.msg)
+		if got {
+			t.Errorf("Eval(%s) holds despite the error", test.condition)
+		}
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := []struct {
+		condition string
+		pos       int
+		msg       string
+	}{
+		{``, 0, "unexpected end of condition"},
+		{`(((`, 3, "unexpected end of condition"},
+		{`(true`, 5, "')' expected instead of end of condition"},
+		{`a ==`, 4, "unexpected end of condition"},
+		{`a = b`, 2, "unexpected character '='"},
+		{`a & b`, 2, "unexpected character '&'"},
+		{`a == b c`, 7, "unexpected c"},
+		{`"abc`, 0, "unterminated string"},
+		{`"abc\`, 4, "dangling escape character"},
+		{`-`, 0, "invalid number -"},
+		{`1.2.3 == a`, 0, "invalid number 1.2.3"},
+		{`a in [(b)]`, 6, "list elements must be values or attributes"},
+		{`a in [b,]`, 8, "list elements must be values or attributes"},
+		{`a in [b c]`, 8, "',' expected instead of c"},
+		{`in == 1`, 0, "unexpected in"},
+		{`) && true`, 0, "unexpected )"},
+	}
+	for _, test := range tests {
+		_, err := ParseCondition(test.condition)
+		checkConditionError(t, test.condition, err, test.pos, test.msg)
+	}
+}
+
+func TestConditionLimits(t *testing.T) {
+	deep := strings.Repeat("(", maxConditionDepth) + "true" + strings.Repeat(")", maxConditionDepth)
+	_, err := ParseCondition(deep)
+	checkConditionError(t, "deep parenthesis", err, maxConditionDepth, "condition too deep")
+
+	shallow := strings.Repeat("(", maxConditionDepth-1) + "true" + strings.Repeat(")", maxConditionDepth-1)
+	if _, err := ParseCondition(shallow); err != nil {
+		t.Errorf("ParseCondition of %d parenthesis : unexpected error %v", maxConditionDepth-1, err)
+	}
+
+	_, err = ParseCondition(strings.Repeat("!", maxConditionDepth+1) + "true")
+	checkConditionError(t, "deep negation", err, maxConditionDepth, "condition too deep")
+
+	long := strings.Repeat("true && ", MaxConditionLength/8) + "true"
+	_, err = ParseCondition(long)
+	checkConditionError(t, "long condition", err, MaxConditionLength, "condition too long")
+
+	previous := MaxConditionLength
+	MaxConditionLength = len(long)
+	defer func() { MaxConditionLength = previous }()
+	if _, err := ParseCondition(long); err != nil {
+		t.Errorf("ParseCondition of %d bytes : unexpected error %v", len(long), err)
+	}
+}
+
+func TestInvalidCondition(t *testing.T) {
+	_, parseErr := ParseCondition(`a ==`)
+	c := invalidCondition(`a ==`, parseErr)
+	holds, err := c.Eval(testAttributes)
+	if holds || err != parseErr {
+		t.Errorf("Eval of an invalid condition = %v, %v, want false, %v", holds, err, parseErr)
+	}
+	holds, err = (&Condition{source: "x"}).Eval(testAttributes)
+	if holds || err == nil {
+		t.Errorf("Eval of an unparsed condition = %v, %v, want false and an error", holds, err)
+	}
+}
+
+func TestCompareCondition(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		cmp     int
+		ordered bool
+		valid   bool
+	}{
+		{"1", "2", -1, true, true},
+		{"2", "1", 1, true, true},
+		{"1e3", "1000", 0, true, true},
+		{"-1", "1", -1, true, true},
+		{"2024-05-01T10:00:00Z", "2024-05-01T11:00:00+01:00", 0, true, true},
+		{"2024-05-01T10:00:00Z", "2024-05-01T10:00:01Z", -1, true, true},
+		{"2024-05-01T10:00:00Z", "10", 1, false, true},
+		{"abc", "abc", 0, false, true},
+		{"abc", "abd", 1, false, true},
+		{"NaN", "NaN", 0, false, false},
+		{"1", "nan", 0, false, false},
+		{"+Inf", "1", 0, false, false},
+		{"1", "-infinity", 0, false, false},
+		{"1e999", "1", 1, false, true},
+	}
+	for _, test := range tests {
+		cmp, ordered, valid := compareCondition(test.a, test.b)
+		if cmp != test.cmp || ordered != test.ordered || valid != test.valid {
+			t.Errorf("compareCondition(%s, %s) = %d, %v, %v, want %d, %v, %v", test.a, test.b, cmp, ordered, valid, test.cmp, test.ordered, test.valid)
+		}
+	}
+}
+
+func TestTokenizeCondition(t *testing.T) {
+	tokens, err := tokenizeCondition(`a.b-c>=-1.5&&"x\"y"!=[z]`)
+	if err != nil {
+		t.Fatalf("tokenizeCondition : unexpected error %v", err)
+	}
+	want := []condToken{
+		{tokenIdent, "a.b-c", 0},
+		{tokenOperator, ">=", 5},
+		{tokenNumber, "-1.5", 7},
+		{tokenOperator, "&&", 11},
+		{tokenString, `x"y`, 13},
+		{tokenOperator, "!=", 19},
+		{tokenOperator, "[", 21},
+		{tokenIdent, "z", 22},
+		{tokenOperator, "]", 23},
+		{tokenEnd, "end of condition", 24},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("tokenizeCondition returned %d tokens, want %d : %v", len(tokens), len(want), tokens)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d = %v, want %v", i, tokens[i], want[i])
+		}
+	}
+}
+
+//checkConditionError fails the test unless err is a *ConditionError at pos whose message contains msg
+func checkConditionError(t *testing.T, condition string, err error, pos int, msg string) {
+	t.Helper()
+	ce, ok := err.(*ConditionError)
+	if !ok {
+		t.Errorf("%s : got error %v, want a *ConditionError", condition, err)
+		return
+	}
+	if ce.Pos != pos || !strings.Contains(ce.Msg, msg) {
+		t.Errorf("%s : got error at %d %q, want at %d %q", condition, ce.Pos, ce.Msg, pos, msg)
+	}
+}
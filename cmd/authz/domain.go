@@ -0,0 +1,89 @@
+package authz
+
+import (
+	"strings"
+	"time"
+)
+
+//Domain regroups users, actions and roles.
+//It also holds the registration settings and the password policy of its users
+type Domain struct {
+	ID                       uint64    `db:"id" json:"id"`
+	Name                     string    `db:"name" json:"name"`
+	TokenHash                string    `db:"token_hash" json:"-"`
+	CreatedAt                time.Time `db:"created_at" json:"created_at"`
+	AllowRegistration        bool      `db:"allow_registration" json:"allow_registration"`
+	PasswordMinLength        int       `db:"password_min_length" json:"password_min_length"`
+	PasswordRequireMixedCase bool      `db:"password_require_mixed_case" json:"password_require_mixed_case"`
+	PasswordRequireDigit     bool      `db:"password_require_digit" json:"password_require_digit"`
+	PasswordRequireSymbol    bool      `db:"password_require_symbol" json:"password_require_symbol"`
+}
+
+//DefaultPasswordMinLength is the minimal password length of new domains
+const DefaultPasswordMinLength = 8
+
+//CreateDomain creates a new domain. Domain names are unique
+func CreateDomain(name string) (*Domain, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+	if _, err := GetDomainByName(name); err != ErrNotFound {
+		if err == nil {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+	d := Domain{Name: name, CreatedAt: time.Now().UTC(), PasswordMinLength: DefaultPasswordMinLength}
+	if err := store.CreateDomain(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+//GetDomainByID returns a domain or ErrNotFound
+func GetDomainByID(id uint64) (*Domain, error) {
+	return store.GetDomain(id)
+}
+
+//GetDomainByName returns a domain or ErrNotFound
+func GetDomainByName(name string) (*Domain, error) {
+	return store.GetDomainByName(name)
+}
+
+//GetDomainByTokenHash returns the domain whose token has this hash or ErrNotFound
+func GetDomainByTokenHash(hash string) (*Domain, error) {
+	if hash == "" {
+		return nil, ErrNotFound
+	}
+	return store.GetDomainByTokenHash(hash)
+}
+
+//GetDomains returns every domain
+func GetDomains() ([]*Domain, error) {
+	return store.GetDomains()
+}
+
+//Save stores the modifications made on the settings of the domain
+func (d *Domain) Save() error {
+	return store.UpdateDomain(d)
+}
+
+//SetTokenHash stores the hash of the domain token
+func (d *Domain) SetTokenHash(hash string) error {
+	d.TokenHash = hash
+	return store.UpdateDomain(d)
+}
+
+//Rename changes the name of the domain
+func (d *Domain) Rename(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrEmptyName
+	}
+	if other, err := GetDomainByName(name); err == nil && other.ID != d.ID {
+		return ErrAlreadyExists
+	}
+	d.Name = name
+	return store.UpdateDomain(d)
+}
@@ -0,0 +1,35 @@
+package authz
+
+import (
+	"gitlab.com/hiveway/getaround-api-catch/cmd/dispatcher"
+)
+
+//names of the events published on the authz channel. The payload is the modified object
+const (
+	EventRightGranted       = "right.granted"        //*Right
+	EventRightRevoked       = "right.revoked"        //*Right
+	EventRightExpiring      = "right.expiring"       //*Right, published ExpiryWarning before its expiration
+	EventRightExpired       = "right.expired"        //*Right, archived by SweepExpiredRights
+	EventRoleDeleted        = "role.deleted"         //*Role
+	EventRoleActionAdded    = "role.action.added"    //*RoleAction
+	EventRoleActionRemoved  = "role.action.removed"  //*RoleAction
+	EventRoleIncludeAdded   = "role.include.added"   //*RoleInclude
+	EventRoleIncludeRemoved = "role.include.removed" //*RoleInclude
+	EventActionDeleted      = "action.deleted"       //*Action
+	EventUserDeleted        = "user.deleted"         //*User
+	EventGroupMemberAdded   = "group.member.added"   //*GroupMember
+	EventGroupMemberRemoved = "group.member.removed" //*GroupMember
+	EventGroupDeleted       = "group.deleted"        //*Group
+)
+
+//Events is the channel on which every modification of the authorization model is published
+var Events = dispatcher.GetOrCreate("authz")
+
+//publish invalidates the cache synchronously, so that the next check sees the modification, then
+//publishes the event
+func publish(eventname string, payload interface{}) {
+	if c := cache; c != nil {
+		c.invalidateEvent(eventname, payload)
+	}
+	Events.Publish(eventname, payload)
+}
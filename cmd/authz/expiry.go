@@ -0,0 +1,87 @@
+package authz
+
+import (
+	"time"
+)
+
+//ExpiryWarning is how long before its expiration EventRightExpiring is published for a right
+var ExpiryWarning = 24 * time.Hour
+
+//RightArchive keeps the rights removed by the expiry sweeper
+type RightArchive struct {
+	ID         uint64    `db:"id" json:"id"`
+	RightID    uint64    `db:"right_id" json:"right_id"`
+	DomainID   uint64    `db:"domain_id" json:"domain_id"`
+	UserID     uint64    `db:"user_id" json:"user_id"`
+	GroupID    uint64    `db:"group_id" json:"group_id,omitempty"`
+	RoleID     uint64    `db:"role_id" json:"role_id"`
+	ObjectID   string    `db:"object_id" json:"object_id"`
+	Deny       bool      `db:"deny" json:"deny"`
+	Condition  string    `db:"condition" json:"condition,omitempty"`
+	ExpiresAt  time.Time `db:"expires_at" json:"expires_at"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ArchivedAt time.Time `db:"archived_at" json:"archived_at"`
+}
+
+//archive moves an expired right to the archive table
+func (r *Right) archive(now time.Time) error {
+	a := RightArchive{
+		RightID:    r.ID,
+		DomainID:   r.DomainID,
+		UserID:     r.UserID,
+		GroupID:    r.GroupID,
+		RoleID:     r.RoleID,
+		ObjectID:   r.ObjectID,
+		Deny:       r.Deny,
+		Condition:  r.Condition,
+		ExpiresAt:  *r.ExpiresAt,
+		CreatedAt:  r.CreatedAt,
+		ArchivedAt: now.UTC(),
+	}
+	if err := store.ArchiveRight(&a); err != nil {
+		return err
+	}
+	if err := store.DeleteRight(r); err != nil {
+		return err
+	}
+	publish(EventRightExpired, r)
+	return nil
+}
+
+//SweepExpiredRights archives the expired rights and warns about the ones expiring soon.
+//Checks ignore expired rights by themselves, the sweep only keeps the tables clean
+//and notifies the subscribers of the Events channel.
+func SweepExpiredRights(now time.Time) (expiring int, expired int, err error) {
+	domains, err := GetDomains()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, d := range domains {
+		rights, err := GetDomainRights(d.ID)
+		if err != nil {
+			return expiring, expired, err
+		}
+		for _, r := range rights {
+			if r.ExpiresAt == nil {
+				continue
+			}
+			if r.Expired(now) {
+				if err := r.archive(now); err != nil {
+					return expiring, expired, err
+				}
+				expired++
+				continue
+			}
+			if r.ExpiryNotifiedAt == nil && r.ExpiresAt.Sub(now) <= ExpiryWarning {
+				notified := now.UTC()
+				r.ExpiryNotifiedAt = &notified
+				if err := store.UpdateRight(r); err != nil {
+					return expiring, expired, err
+				}
+				publish(EventRightExpiring, r)
+				expiring++
+			}
+		}
+	}
+	return expiring, expired, nil
+}
@@ -0,0 +1,857 @@
+package authz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//fileData is the content of a flat file store
+type fileData struct {
+	LastID        uint64
+	Domains       []*Domain
+	Users         []*User
+	Actions       []*Action
+	Roles         []*Role
+	RoleActions   []*RoleAction
+	RoleIncludes  []*RoleInclude
+	Rights        []*Right
+	RightArchives []*RightArchive
+	Groups        []*Group
+	GroupMembers  []*GroupMember
+	GroupAudits   []*GroupAudit
+}
+
+//fileDomain, fileUser and fileRight keep in the file the fields hidden from the JSON API
+type fileDomain struct {
+	Domain
+	TokenHash string `json:"token_hash"`
+}
+
+type fileUser struct {
+	User
+	Password              string     `json:"password"`
+	VerificationTokenHash string     `json:"verification_token_hash,omitempty"`
+	VerificationExpiresAt *time.Time `json:"verification_expires_at,omitempty"`
+}
+
+type fileRight struct {
+	Right
+	ExpiryNotifiedAt *time.Time `json:"expiry_notified_at,omitempty"`
+}
+
+//fileContent is the JSON document written on disk
+type fileContent struct {
+	LastID        uint64          `json:"last_id"`
+	Domains       []fileDomain    `json:"domains"`
+	Users         []fileUser      `json:"users"`
+	Actions       []*Action       `json:"actions"`
+	Roles         []*Role         `json:"roles"`
+	RoleActions   []*RoleAction   `json:"role_actions"`
+	RoleIncludes  []*RoleInclude  `json:"role_includes"`
+	Rights        []fileRight     `json:"rights"`
+	RightArchives []*RightArchive `json:"right_archives"`
+	Groups        []*Group        `json:"groups"`
+	GroupMembers  []*GroupMember  `json:"group_members"`
+	GroupAudits   []*GroupAudit   `json:"group_audits"`
+}
+
+//MarshalJSON writes the content along with the hidden fields
+func (d *fileData) MarshalJSON() ([]byte, error) {
+	c := fileContent{
+		LastID:        d.LastID,
+		Domains:       make([]fileDomain, len(d.Domains)),
+		Users:         make([]fileUser, len(d.Users)),
+		Actions:       d.Actions,
+		Roles:         d.Roles,
+		RoleActions:   d.RoleActions,
+		RoleIncludes:  d.RoleIncludes,
+		Rights:        make([]fileRight, len(d.Rights)),
+		RightArchives: d.RightArchives,
+		Groups:        d.Groups,
+		GroupMembers:  d.GroupMembers,
+		GroupAudits:   d.GroupAudits,
+	}
+	for i, v := range d.Domains {
+		c.Domains[i] = fileDomain{Domain: *v, TokenHash: v.TokenHash}
+	}
+	for i, v := range d.Users {
+		c.Users[i] = fileUser{
+			User:                  *v,
+			Password:              v.Password,
+			VerificationTokenHash: v.VerificationTokenHash,
+			VerificationExpiresAt: v.VerificationExpiresAt,
+		}
+	}
+	for i, v := range d.Rights {
+		c.Rights[i] = fileRight{Right: *v, ExpiryNotifiedAt: v.ExpiryNotifiedAt}
+	}
+	return json.Marshal(&c)
+}
+
+//UnmarshalJSON reads the content along with the hidden fields
+func (d *fileData) UnmarshalJSON(b []byte) error {
+	c := fileContent{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	*d = fileData{
+		LastID:        c.LastID,
+		Domains:       make([]*Domain, len(c.Domains)),
+		Users:         make([]*User, len(c.Users)),
+		Actions:       c.Actions,
+		Roles:         c.Roles,
+		RoleActions:   c.RoleActions,
+		RoleIncludes:  c.RoleIncludes,
+		Rights:        make([]*Right, len(c.Rights)),
+		RightArchives: c.RightArchives,
+		Groups:        c.Groups,
+		GroupMembers:  c.GroupMembers,
+		GroupAudits:   c.GroupAudits,
+	}
+	for i := range c.Domains {
+		c.Domains[i].Domain.TokenHash = c.Domains[i].TokenHash
+		d.Domains[i] = &c.Domains[i].Domain
+	}
+	for i := range c.Users {
+		c.Users[i].User.Password = c.Users[i].Password
+		c.Users[i].User.VerificationTokenHash = c.Users[i].VerificationTokenHash
+		c.Users[i].User.VerificationExpiresAt = c.Users[i].VerificationExpiresAt
+		d.Users[i] = &c.Users[i].User
+	}
+	for i := range c.Rights {
+		c.Rights[i].Right.ExpiryNotifiedAt = c.Rights[i].ExpiryNotifiedAt
+		d.Rights[i] = &c.Rights[i].Right
+	}
+	return nil
+}
+
+//fileStore keeps the whole model in a JSON file, for small deployments and tests.
+//The file is read again when modified by another process, rewritten atomically
+//on every modification and protected by an advisory lock on path + ".lock"
+type fileStore struct {
+	path    string
+	mutex   sync.Mutex
+	data    fileData
+	modTime time.Time
+	size    int64
+}
+
+//NewFileStore opens a flat file store, creating the file if it does not exist
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{path: path}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := s.write(func(d *fileData) error { return nil }); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if err := s.read(func(d *fileData) {}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//lock takes the advisory file lock, LOCK_SH or LOCK_EX, and returns the unlock function
+func (s *fileStore) lock(how int) (func(), error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+//reload reads the file again if it changed since the last read. mutex and file lock must be held
+func (s *fileStore) reload() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.data = fileData{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	data := fileData{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return err
+	}
+	s.data = data
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return nil
+}
+
+//save rewrites the file atomically : a temporary file is written then renamed
+func (s *fileStore) save() error {
+	content, err := json.MarshalIndent(&s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return nil
+}
+
+//read runs a read only function on an up to date content
+func (s *fileStore) read(fn func(d *fileData)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	unlock, err := s.lock(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	fn(&s.data)
+	return nil
+}
+
+//write runs a modifying function on an up to date content and saves the result.
+//fn must check everything before modifying the content
+func (s *fileStore) write(fn func(d *fileData) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	unlock, err := s.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := fn(&s.data); err != nil {
+		return err
+	}
+	if err := s.save(); err != nil {
+		//force a reload, the content in memory is not the one on disk
+		s.modTime = time.Time{}
+		return err
+	}
+	return nil
+}
+
+//nextID returns a new ID, unique among every record of the store
+func (d *fileData) nextID() uint64 {
+	d.LastID++
+	return d.LastID
+}
+
+//getOne runs a lookup returning a copy of the record, ErrNotFound if lookup found nothing
+func (s *fileStore) getOne(lookup func(d *fileData) bool) error {
+	found := false
+	if err := s.read(func(d *fileData) { found = lookup(d) }); err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *fileStore) CreateDomain(dom *Domain) error {
+	return s.write(func(d *fileData) error {
+		dom.ID = d.nextID()
+		cp := *dom
+		d.Domains = append(d.Domains, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) UpdateDomain(dom *Domain) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Domains {
+			if v.ID == dom.ID {
+				cp := *dom
+				d.Domains[i] = &cp
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findDomain(match func(v *Domain) bool) (*Domain, error) {
+	var result Domain
+	err := s.getOne(func(d *fileData) bool {
+		for _, v := range d.Domains {
+			if match(v) {
+				result = *v
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *fileStore) GetDomain(id uint64) (*Domain, error) {
+	return s.findDomain(func(v *Domain) bool { return v.ID == id })
+}
+
+func (s *fileStore) GetDomainByName(name string) (*Domain, error) {
+	return s.findDomain(func(v *Domain) bool { return v.Name == name })
+}
+
+func (s *fileStore) GetDomainByTokenHash(hash string) (*Domain, error) {
+	return s.findDomain(func(v *Domain) bool { return v.TokenHash == hash })
+}
+
+func (s *fileStore) GetDomains() ([]*Domain, error) {
+	result := []*Domain{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.Domains {
+			cp := *v
+			result = append(result, &cp)
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, err
+}
+
+func (s *fileStore) CreateUser(u *User) error {
+	return s.write(func(d *fileData) error {
+		u.ID = d.nextID()
+		cp := *u
+		d.Users = append(d.Users, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) UpdateUser(u *User) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Users {
+			if v.ID == u.ID {
+				cp := *u
+				d.Users[i] = &cp
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) DeleteUser(u *User) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Users {
+			if v.ID == u.ID {
+				d.Users = append(d.Users[:i], d.Users[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findUser(match func(v *User) bool) (*User, error) {
+	var result User
+	err := s.getOne(func(d *fileData) bool {
+		for _, v := range d.Users {
+			if match(v) {
+				result = *v
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *fileStore) GetUser(id uint64) (*User, error) {
+	return s.findUser(func(v *User) bool { return v.ID == id })
+}
+
+func (s *fileStore) GetUserByLogin(domainID uint64, login string) (*User, error) {
+	return s.findUser(func(v *User) bool { return v.DomainID == domainID && v.Login == login })
+}
+
+func (s *fileStore) GetUsers(domainID uint64) ([]*User, error) {
+	result := []*User{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.Users {
+			if v.DomainID == domainID {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Login < result[j].Login })
+	return result, err
+}
+
+func (s *fileStore) CreateAction(a *Action) error {
+	return s.write(func(d *fileData) error {
+		a.ID = d.nextID()
+		cp := *a
+		d.Actions = append(d.Actions, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) DeleteAction(a *Action) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Actions {
+			if v.ID == a.ID {
+				d.Actions = append(d.Actions[:i], d.Actions[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findAction(match func(v *Action) bool) (*Action, error) {
+	var result Action
+	err := s.getOne(func(d *fileData) bool {
+		for _, v := range d.Actions {
+			if match(v) {
+				result = *v
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *fileStore) GetAction(id uint64) (*Action, error) {
+	return s.findAction(func(v *Action) bool { return v.ID == id })
+}
+
+func (s *fileStore) GetActionByName(domainID uint64, name string) (*Action, error) {
+	return s.findAction(func(v *Action) bool { return v.DomainID == domainID && v.Name == name })
+}
+
+func (s *fileStore) GetActions(domainID uint64) ([]*Action, error) {
+	result := []*Action{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.Actions {
+			if v.DomainID == domainID {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, err
+}
+
+func (s *fileStore) CreateRole(r *Role) error {
+	return s.write(func(d *fileData) error {
+		r.ID = d.nextID()
+		cp := *r
+		d.Roles = append(d.Roles, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) UpdateRole(r *Role) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Roles {
+			if v.ID == r.ID {
+				cp := *r
+				d.Roles[i] = &cp
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) DeleteRole(r *Role) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Roles {
+			if v.ID == r.ID {
+				d.Roles = append(d.Roles[:i], d.Roles[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findRole(match func(v *Role) bool) (*Role, error) {
+	var result Role
+	err := s.getOne(func(d *fileData) bool {
+		for _, v := range d.Roles {
+			if match(v) {
+				result = *v
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *fileStore) GetRole(id uint64) (*Role, error) {
+	return s.findRole(func(v *Role) bool { return v.ID == id })
+}
+
+func (s *fileStore) GetRoleByName(domainID uint64, name string) (*Role, error) {
+	return s.findRole(func(v *Role) bool { return v.DomainID == domainID && v.Name == name })
+}
+
+func (s *fileStore) GetRoles(domainID uint64) ([]*Role, error) {
+	result := []*Role{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.Roles {
+			if v.DomainID == domainID {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, err
+}
+
+func (s *fileStore) CreateRoleAction(ra *RoleAction) error {
+	return s.write(func(d *fileData) error {
+		ra.ID = d.nextID()
+		cp := *ra
+		d.RoleActions = append(d.RoleActions, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) DeleteRoleAction(ra *RoleAction) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.RoleActions {
+			if v.ID == ra.ID {
+				d.RoleActions = append(d.RoleActions[:i], d.RoleActions[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findRoleActions(match func(v *RoleAction) bool) ([]*RoleAction, error) {
+	result := []*RoleAction{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.RoleActions {
+			if match(v) {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	return result, err
+}
+
+func (s *fileStore) GetRoleActions(roleID uint64) ([]*RoleAction, error) {
+	return s.findRoleActions(func(v *RoleAction) bool { return v.RoleID == roleID })
+}
+
+func (s *fileStore) GetActionRoles(actionID uint64) ([]*RoleAction, error) {
+	return s.findRoleActions(func(v *RoleAction) bool { return v.ActionID == actionID })
+}
+
+func (s *fileStore) CreateRoleInclude(ri *RoleInclude) error {
+	return s.write(func(d *fileData) error {
+		ri.ID = d.nextID()
+		cp := *ri
+		d.RoleIncludes = append(d.RoleIncludes, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) DeleteRoleInclude(ri *RoleInclude) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.RoleIncludes {
+			if v.ID == ri.ID {
+				d.RoleIncludes = append(d.RoleIncludes[:i], d.RoleIncludes[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findRoleIncludes(match func(v *RoleInclude) bool) ([]*RoleInclude, error) {
+	result := []*RoleInclude{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.RoleIncludes {
+			if match(v) {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	return result, err
+}
+
+func (s *fileStore) GetRoleIncludes(roleID uint64) ([]*RoleInclude, error) {
+	return s.findRoleIncludes(func(v *RoleInclude) bool { return v.RoleID == roleID })
+}
+
+func (s *fileStore) GetIncludingRoles(includedRoleID uint64) ([]*RoleInclude, error) {
+	return s.findRoleIncludes(func(v *RoleInclude) bool { return v.IncludedRoleID == includedRoleID })
+}
+
+func (s *fileStore) CreateRight(r *Right) error {
+	return s.write(func(d *fileData) error {
+		r.ID = d.nextID()
+		cp := *r
+		d.Rights = append(d.Rights, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) UpdateRight(r *Right) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Rights {
+			if v.ID == r.ID {
+				cp := *r
+				d.Rights[i] = &cp
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) DeleteRight(r *Right) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Rights {
+			if v.ID == r.ID {
+				d.Rights = append(d.Rights[:i], d.Rights[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findRights(match func(v *Right) bool) ([]*Right, error) {
+	result := []*Right{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.Rights {
+			if match(v) {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	return result, err
+}
+
+func (s *fileStore) GetRight(id uint64) (*Right, error) {
+	rights, err := s.findRights(func(v *Right) bool { return v.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	if len(rights) == 0 {
+		return nil, ErrNotFound
+	}
+	return rights[0], nil
+}
+
+func (s *fileStore) GetUserRights(userID uint64) ([]*Right, error) {
+	return s.findRights(func(v *Right) bool { return v.UserID == userID })
+}
+
+func (s *fileStore) GetGroupRights(groupID uint64) ([]*Right, error) {
+	return s.findRights(func(v *Right) bool { return v.GroupID == groupID })
+}
+
+func (s *fileStore) GetRoleRights(roleID uint64) ([]*Right, error) {
+	return s.findRights(func(v *Right) bool { return v.RoleID == roleID })
+}
+
+func (s *fileStore) GetDomainRights(domainID uint64) ([]*Right, error) {
+	return s.findRights(func(v *Right) bool { return v.DomainID == domainID })
+}
+
+func (s *fileStore) GetObjectRights(domainID uint64, objectID string) ([]*Right, error) {
+	return s.findRights(func(v *Right) bool { return v.DomainID == domainID && v.ObjectID == objectID })
+}
+
+func (s *fileStore) ArchiveRight(a *RightArchive) error {
+	return s.write(func(d *fileData) error {
+		a.ID = d.nextID()
+		cp := *a
+		d.RightArchives = append(d.RightArchives, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) CreateGroup(g *Group) error {
+	return s.write(func(d *fileData) error {
+		g.ID = d.nextID()
+		cp := *g
+		d.Groups = append(d.Groups, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) DeleteGroup(g *Group) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.Groups {
+			if v.ID == g.ID {
+				d.Groups = append(d.Groups[:i], d.Groups[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findGroup(match func(v *Group) bool) (*Group, error) {
+	var result Group
+	err := s.getOne(func(d *fileData) bool {
+		for _, v := range d.Groups {
+			if match(v) {
+				result = *v
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *fileStore) GetGroup(id uint64) (*Group, error) {
+	return s.findGroup(func(v *Group) bool { return v.ID == id })
+}
+
+func (s *fileStore) GetGroupByName(domainID uint64, name string) (*Group, error) {
+	return s.findGroup(func(v *Group) bool { return v.DomainID == domainID && v.Name == name })
+}
+
+func (s *fileStore) GetGroups(domainID uint64) ([]*Group, error) {
+	result := []*Group{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.Groups {
+			if v.DomainID == domainID {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, err
+}
+
+func (s *fileStore) CreateGroupMember(m *GroupMember) error {
+	return s.write(func(d *fileData) error {
+		m.ID = d.nextID()
+		cp := *m
+		d.GroupMembers = append(d.GroupMembers, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) DeleteGroupMember(m *GroupMember) error {
+	return s.write(func(d *fileData) error {
+		for i, v := range d.GroupMembers {
+			if v.ID == m.ID {
+				d.GroupMembers = append(d.GroupMembers[:i], d.GroupMembers[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *fileStore) findGroupMembers(match func(v *GroupMember) bool) ([]*GroupMember, error) {
+	result := []*GroupMember{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.GroupMembers {
+			if match(v) {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	return result, err
+}
+
+func (s *fileStore) GetGroupMembers(groupID uint64) ([]*GroupMember, error) {
+	return s.findGroupMembers(func(v *GroupMember) bool { return v.GroupID == groupID })
+}
+
+func (s *fileStore) GetUserGroups(userID uint64) ([]*GroupMember, error) {
+	return s.findGroupMembers(func(v *GroupMember) bool { return v.UserID == userID })
+}
+
+func (s *fileStore) CreateGroupAudit(a *GroupAudit) error {
+	return s.write(func(d *fileData) error {
+		a.ID = d.nextID()
+		cp := *a
+		d.GroupAudits = append(d.GroupAudits, &cp)
+		return nil
+	})
+}
+
+func (s *fileStore) GetGroupAudit(groupID uint64) ([]*GroupAudit, error) {
+	result := []*GroupAudit{}
+	err := s.read(func(d *fileData) {
+		for _, v := range d.GroupAudits {
+			if v.GroupID == groupID {
+				cp := *v
+				result = append(result, &cp)
+			}
+		}
+	})
+	return result, err
+}
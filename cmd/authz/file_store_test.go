@@ -0,0 +1,101 @@
+package authz
+
+import (
+	"testing"
+)
+
+func TestFileStorePersistence(t *testing.T) {
+	path := useTestStore(t)
+	m := newTestModel(t)
+	if err := m.domain.SetTokenHash("tokenhash"); err != nil {
+		t.Fatalf("SetTokenHash : %v", err)
+	}
+	m.user.Password = "passwordhash"
+	if err := m.user.Save(); err != nil {
+		t.Fatalf("Save : %v", err)
+	}
+	if _, err := GrantConditionalRight(m.user.ID, m.maintainer.ID, "garage(28)", `name == "bob"`, nil); err != nil {
+		t.Fatalf("GrantConditionalRight : %v", err)
+	}
+	if _, err := DenyRight(m.user.ID, m.maintainer.ID, "garage(28)car(123)", nil); err != nil {
+		t.Fatalf("DenyRight : %v", err)
+	}
+	g, err := CreateGroup(m.domain.ID, "mechanics", "", "admin")
+	if err != nil {
+		t.Fatalf("CreateGroup : %v", err)
+	}
+	if err := g.AddMember(m.user, "admin"); err != nil {
+		t.Fatalf("AddMember : %v", err)
+	}
+
+	//a second store on the same file, as another process would open it
+	reopened, err := NewStore("file://" + path)
+	if err != nil {
+		t.Fatalf("NewStore : %v", err)
+	}
+	SetStore(reopened)
+	d, err := GetDomainByTokenHash("tokenhash")
+	if err != nil || d.ID != m.domain.ID {
+		t.Fatalf("GetDomainByTokenHash = %+v, %v, want the domain %d", d, err, m.domain.ID)
+	}
+	u, err := GetUserByLogin(m.domain.ID, "bob")
+	if err != nil || u.ID != m.user.ID || u.Password != "passwordhash" {
+		t.Fatalf("GetUserByLogin = %+v, %v, want bob and his password hash", u, err)
+	}
+	if _, err := GetGroupByName(m.domain.ID, "mechanics"); err != nil {
+		t.Errorf("GetGroupByName : %v", err)
+	}
+	if ids, err := GetUserGroupIDs(m.user.ID); err != nil || len(ids) != 1 || ids[0] != g.ID {
+		t.Errorf("GetUserGroupIDs = %v, %v, want [%d]", ids, err, g.ID)
+	}
+	tests := []struct {
+		object  string
+		name    string
+		allowed bool
+	}{
+		{"garage(28)car(124)", "bob", true},
+		{"garage(28)car(124)", "alice", false},
+		{"garage(28)car(123)", "bob", false},
+	}
+	for _, test := range tests {
+		decision, err := Check(m.user.ID, test.object, "carOpen", nil, Attributes{"name": test.name})
+		if err != nil {
+			t.Fatalf("Check(%s) : %v", test.object, err)
+		}
+		if decision.Allowed != test.allowed {
+			t.Errorf("Check(%s) with name %s = %v, want %v", test.object, test.name, decision.Allowed, test.allowed)
+		}
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	path := useTestStore(t)
+	first := store
+	other, err := NewStore("file://" + path)
+	if err != nil {
+		t.Fatalf("NewStore : %v", err)
+	}
+	if _, err := CreateDomain("getaround"); err != nil {
+		t.Fatalf("CreateDomain : %v", err)
+	}
+
+	//the other store reads the modifications of the first one, and the other way round
+	SetStore(other)
+	if _, err := GetDomainByName("getaround"); err != nil {
+		t.Fatalf("GetDomainByName on the other store : %v", err)
+	}
+	if _, err := CreateDomain("drivy"); err != nil {
+		t.Fatalf("CreateDomain : %v", err)
+	}
+	SetStore(first)
+	domains, err := GetDomains()
+	if err != nil || len(domains) != 2 {
+		t.Fatalf("GetDomains = %v, %v, want both domains", domains, err)
+	}
+	if domains[0].ID == domains[1].ID {
+		t.Errorf("both stores gave the ID %d", domains[0].ID)
+	}
+	if _, err := CreateDomain("drivy"); err != ErrAlreadyExists {
+		t.Errorf("CreateDomain of an existing name = %v, want %v", err, ErrAlreadyExists)
+	}
+}
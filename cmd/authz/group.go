@@ -0,0 +1,216 @@
+package authz
+
+import (
+	"strings"
+	"time"
+)
+
+//Group gathers users of a domain. Rights granted to a group apply to every member
+type Group struct {
+	ID          uint64    `db:"id" json:"id"`
+	DomainID    uint64    `db:"domain_id" json:"domain_id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+//GroupMember links a user to a group
+type GroupMember struct {
+	ID        uint64    `db:"id" json:"id"`
+	GroupID   uint64    `db:"group_id" json:"group_id"`
+	UserID    uint64    `db:"user_id" json:"user_id"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+//operations recorded in the audit trail of the groups
+const (
+	GroupAuditCreated       = "created"
+	GroupAuditDeleted       = "deleted"
+	GroupAuditMemberAdded   = "member_added"
+	GroupAuditMemberRemoved = "member_removed"
+)
+
+//GroupAudit records a modification of a group : who did what, and to which user for memberships
+type GroupAudit struct {
+	ID        uint64    `db:"id" json:"id"`
+	DomainID  uint64    `db:"domain_id" json:"domain_id"`
+	GroupID   uint64    `db:"group_id" json:"group_id"`
+	UserID    uint64    `db:"user_id" json:"user_id,omitempty"`
+	Operation string    `db:"operation" json:"operation"`
+	Actor     string    `db:"actor" json:"actor"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+//audit records an operation on the group. actor describes who asked for it
+func (g *Group) audit(operation string, userID uint64, actor string) error {
+	a := GroupAudit{
+		DomainID:  g.DomainID,
+		GroupID:   g.ID,
+		UserID:    userID,
+		Operation: operation,
+		Actor:     actor,
+		CreatedAt: time.Now().UTC(),
+	}
+	return store.CreateGroupAudit(&a)
+}
+
+//CreateGroup creates a group in a domain
+func CreateGroup(domainID uint64, name string, description string, actor string) (*Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+	if _, err := GetDomainByID(domainID); err != nil {
+		return nil, err
+	}
+	if _, err := GetGroupByName(domainID, name); err != ErrNotFound {
+		if err == nil {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+	g := Group{DomainID: domainID, Name: name, Description: description, CreatedAt: time.Now().UTC()}
+	if err := store.CreateGroup(&g); err != nil {
+		return nil, err
+	}
+	if err := g.audit(GroupAuditCreated, 0, actor); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+//GetGroupByID returns a group or ErrNotFound
+func GetGroupByID(id uint64) (*Group, error) {
+	return store.GetGroup(id)
+}
+
+//GetGroupByName returns the group of the domain with this name or ErrNotFound
+func GetGroupByName(domainID uint64, name string) (*Group, error) {
+	return store.GetGroupByName(domainID, name)
+}
+
+//GetGroups returns every group of a domain
+func GetGroups(domainID uint64) ([]*Group, error) {
+	return store.GetGroups(domainID)
+}
+
+//findMember returns the membership of a user or ErrNotFound
+func (g *Group) findMember(userID uint64) (*GroupMember, error) {
+	members, err := store.GetGroupMembers(g.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if m.UserID == userID {
+			return m, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+//AddMember adds a user of the same domain to the group
+func (g *Group) AddMember(u *User, actor string) error {
+	if g.DomainID != u.DomainID {
+		return ErrDomainMismatch
+	}
+	if _, err := g.findMember(u.ID); err != ErrNotFound {
+		if err == nil {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	m := GroupMember{GroupID: g.ID, UserID: u.ID, CreatedAt: time.Now().UTC()}
+	if err := store.CreateGroupMember(&m); err != nil {
+		return err
+	}
+	publish(EventGroupMemberAdded, &m)
+	return g.audit(GroupAuditMemberAdded, u.ID, actor)
+}
+
+//RemoveMember removes a user from the group
+func (g *Group) RemoveMember(userID uint64, actor string) error {
+	m, err := g.findMember(userID)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteGroupMember(m); err != nil {
+		return err
+	}
+	publish(EventGroupMemberRemoved, m)
+	return g.audit(GroupAuditMemberRemoved, userID, actor)
+}
+
+//GetMembers returns the memberships of the group
+func (g *Group) GetMembers() ([]*GroupMember, error) {
+	return store.GetGroupMembers(g.ID)
+}
+
+//GetRights returns every right granted to the group, expired or not
+func (g *Group) GetRights() ([]*Right, error) {
+	return store.GetGroupRights(g.ID)
+}
+
+//GetAudit returns the audit trail of the group, oldest first
+func (g *Group) GetAudit() ([]*GroupAudit, error) {
+	return store.GetGroupAudit(g.ID)
+}
+
+//Delete removes the group along with its memberships and its rights. The audit trail is kept
+func (g *Group) Delete(actor string) error {
+	rights, err := g.GetRights()
+	if err != nil {
+		return err
+	}
+	for _, r := range rights {
+		if err := r.Revoke(); err != nil {
+			return err
+		}
+	}
+	members, err := g.GetMembers()
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err := store.DeleteGroupMember(m); err != nil {
+			return err
+		}
+	}
+	if err := store.DeleteGroup(g); err != nil {
+		return err
+	}
+	publish(EventGroupDeleted, g)
+	return g.audit(GroupAuditDeleted, 0, actor)
+}
+
+//GetUserGroupIDs returns the IDs of the groups of a user
+func GetUserGroupIDs(userID uint64) ([]uint64, error) {
+	memberships, err := store.GetUserGroups(userID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]uint64, len(memberships))
+	for i, m := range memberships {
+		result[i] = m.GroupID
+	}
+	return result, nil
+}
+
+//getHolderRights returns the rights of a user along with the rights of their groups
+func getHolderRights(userID uint64) ([]*Right, error) {
+	rights, err := GetUserRights(userID)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs, err := GetUserGroupIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range groupIDs {
+		groupRights, err := store.GetGroupRights(id)
+		if err != nil {
+			return nil, err
+		}
+		rights = append(rights, groupRights...)
+	}
+	return rights, nil
+}
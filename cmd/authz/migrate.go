@@ -0,0 +1,123 @@
+package authz
+
+import (
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//migrations are played in order and must be idempotent
+var migrations = []string{
+	"CREATE TABLE IF NOT EXISTS `domain` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`name` VARCHAR(190) NOT NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `domain_name` (`name`))",
+	"CREATE TABLE IF NOT EXISTS `user` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`login` VARCHAR(190) NOT NULL," +
+		"`email` VARCHAR(190) NOT NULL DEFAULT ''," +
+		"`password` VARCHAR(255) NOT NULL DEFAULT ''," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `user_login` (`domain_id`, `login`))",
+	"CREATE TABLE IF NOT EXISTS `action` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`name` VARCHAR(190) NOT NULL," +
+		"`description` TEXT," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `action_name` (`domain_id`, `name`))",
+	"CREATE TABLE IF NOT EXISTS `role` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`name` VARCHAR(190) NOT NULL," +
+		"`description` TEXT," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `role_name` (`domain_id`, `name`))",
+	"CREATE TABLE IF NOT EXISTS `role_action` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`role_id` BIGINT UNSIGNED NOT NULL," +
+		"`action_id` BIGINT UNSIGNED NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `role_action_link` (`role_id`, `action_id`))",
+	"CREATE TABLE IF NOT EXISTS `right` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`role_id` BIGINT UNSIGNED NOT NULL," +
+		"`object_id` VARCHAR(512) NOT NULL," +
+		"`expires_at` DATETIME NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"KEY `right_user` (`user_id`)," +
+		"KEY `right_role` (`role_id`))",
+	"ALTER TABLE `domain` ADD COLUMN IF NOT EXISTS `token_hash` VARCHAR(64) NOT NULL DEFAULT ''",
+	"ALTER TABLE `right` ADD COLUMN IF NOT EXISTS `expiry_notified_at` DATETIME NULL",
+	"CREATE TABLE IF NOT EXISTS `right_archive` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`right_id` BIGINT UNSIGNED NOT NULL," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`role_id` BIGINT UNSIGNED NOT NULL," +
+		"`object_id` VARCHAR(512) NOT NULL," +
+		"`expires_at` DATETIME NOT NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"`archived_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"KEY `right_archive_user` (`user_id`))",
+	"ALTER TABLE `domain` ADD COLUMN IF NOT EXISTS `allow_registration` TINYINT(1) NOT NULL DEFAULT 0," +
+		"ADD COLUMN IF NOT EXISTS `password_min_length` INT NOT NULL DEFAULT 8," +
+		"ADD COLUMN IF NOT EXISTS `password_require_mixed_case` TINYINT(1) NOT NULL DEFAULT 0," +
+		"ADD COLUMN IF NOT EXISTS `password_require_digit` TINYINT(1) NOT NULL DEFAULT 0," +
+		"ADD COLUMN IF NOT EXISTS `password_require_symbol` TINYINT(1) NOT NULL DEFAULT 0",
+	"ALTER TABLE `user` ADD COLUMN IF NOT EXISTS `verification_pending` TINYINT(1) NOT NULL DEFAULT 0," +
+		"ADD COLUMN IF NOT EXISTS `verification_token_hash` VARCHAR(64) NOT NULL DEFAULT ''," +
+		"ADD COLUMN IF NOT EXISTS `verification_expires_at` DATETIME NULL",
+	"ALTER TABLE `role` ADD COLUMN IF NOT EXISTS `require_mfa` TINYINT(1) NOT NULL DEFAULT 0",
+	"CREATE TABLE IF NOT EXISTS `role_include` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`role_id` BIGINT UNSIGNED NOT NULL," +
+		"`included_role_id` BIGINT UNSIGNED NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `role_include_link` (`role_id`,`included_role_id`)," +
+		"KEY `role_include_included` (`included_role_id`))",
+	"ALTER TABLE `right` ADD COLUMN IF NOT EXISTS `deny` TINYINT(1) NOT NULL DEFAULT 0",
+	"ALTER TABLE `right_archive` ADD COLUMN IF NOT EXISTS `deny` TINYINT(1) NOT NULL DEFAULT 0",
+	"CREATE TABLE IF NOT EXISTS `group` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`name` VARCHAR(190) NOT NULL," +
+		"`description` TEXT," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `group_domain_name` (`domain_id`,`name`))",
+	"CREATE TABLE IF NOT EXISTS `group_member` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`group_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"UNIQUE KEY `group_member_link` (`group_id`,`user_id`)," +
+		"KEY `group_member_user` (`user_id`))",
+	"CREATE TABLE IF NOT EXISTS `group_audit` (" +
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT," +
+		"`domain_id` BIGINT UNSIGNED NOT NULL," +
+		"`group_id` BIGINT UNSIGNED NOT NULL," +
+		"`user_id` BIGINT UNSIGNED NOT NULL DEFAULT 0," +
+		"`operation` VARCHAR(32) NOT NULL," +
+		"`actor` VARCHAR(190) NOT NULL DEFAULT ''," +
+		"`created_at` DATETIME NOT NULL," +
+		"PRIMARY KEY (`id`)," +
+		"KEY `group_audit_group` (`group_id`))",
+	"ALTER TABLE `right` ADD COLUMN IF NOT EXISTS `group_id` BIGINT UNSIGNED NOT NULL DEFAULT 0," +
+		"ADD INDEX IF NOT EXISTS `right_group` (`group_id`)",
+	"ALTER TABLE `right_archive` ADD COLUMN IF NOT EXISTS `group_id` BIGINT UNSIGNED NOT NULL DEFAULT 0",
+	"ALTER TABLE `right` ADD COLUMN IF NOT EXISTS `condition` TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE `right_archive` ADD COLUMN IF NOT EXISTS `condition` TEXT NOT NULL DEFAULT ''",
+}
+
+//MigrateDB creates the authz tables if they do not exist yet
+func MigrateDB(dataSource string) error {
+	return tools.MigrateDB(dataSource, migrations)
+}
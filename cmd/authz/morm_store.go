@@ -0,0 +1,409 @@
+package authz
+
+import (
+	"github.com/marcmorel/morm"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
+)
+
+//mormStore stores the model in SQL through morm. Tables are created by MigrateDB
+type mormStore struct{}
+
+func domainFromRow(row map[string]interface{}) *Domain {
+	return &Domain{
+		ID:                       tools.RowUint(row, "id"),
+		Name:                     morm.SafeString(row["name"]),
+		TokenHash:                morm.SafeString(row["token_hash"]),
+		CreatedAt:                tools.RowTime(row, "created_at"),
+		AllowRegistration:        tools.RowBool(row, "allow_registration"),
+		PasswordMinLength:        tools.RowInt(row, "password_min_length"),
+		PasswordRequireMixedCase: tools.RowBool(row, "password_require_mixed_case"),
+		PasswordRequireDigit:     tools.RowBool(row, "password_require_digit"),
+		PasswordRequireSymbol:    tools.RowBool(row, "password_require_symbol"),
+	}
+}
+
+func userFromRow(row map[string]interface{}) *User {
+	return &User{
+		ID:                    tools.RowUint(row, "id"),
+		DomainID:              tools.RowUint(row, "domain_id"),
+		Login:                 morm.SafeString(row["login"]),
+		Email:                 morm.SafeString(row["email"]),
+		Password:              morm.SafeString(row["password"]),
+		CreatedAt:             tools.RowTime(row, "created_at"),
+		VerificationPending:   tools.RowBool(row, "verification_pending"),
+		VerificationTokenHash: morm.SafeString(row["verification_token_hash"]),
+		VerificationExpiresAt: morm.SafeTime(row["verification_expires_at"]),
+	}
+}
+
+func actionFromRow(row map[string]interface{}) *Action {
+	return &Action{
+		ID:          tools.RowUint(row, "id"),
+		DomainID:    tools.RowUint(row, "domain_id"),
+		Name:        morm.SafeString(row["name"]),
+		Description: morm.SafeString(row["description"]),
+	}
+}
+
+func roleFromRow(row map[string]interface{}) *Role {
+	return &Role{
+		ID:          tools.RowUint(row, "id"),
+		DomainID:    tools.RowUint(row, "domain_id"),
+		Name:        morm.SafeString(row["name"]),
+		Description: morm.SafeString(row["description"]),
+		RequireMFA:  tools.RowBool(row, "require_mfa"),
+	}
+}
+
+func roleActionFromRow(row map[string]interface{}) *RoleAction {
+	return &RoleAction{
+		ID:       tools.RowUint(row, "id"),
+		RoleID:   tools.RowUint(row, "role_id"),
+		ActionID: tools.RowUint(row, "action_id"),
+	}
+}
+
+func roleIncludeFromRow(row map[string]interface{}) *RoleInclude {
+	return &RoleInclude{
+		ID:             tools.RowUint(row, "id"),
+		RoleID:         tools.RowUint(row, "role_id"),
+		IncludedRoleID: tools.RowUint(row, "included_role_id"),
+	}
+}
+
+func groupFromRow(row map[string]interface{}) *Group {
+	return &Group{
+		ID:          tools.RowUint(row, "id"),
+		DomainID:    tools.RowUint(row, "domain_id"),
+		Name:        morm.SafeString(row["name"]),
+		Description: morm.SafeString(row["description"]),
+		CreatedAt:   tools.RowTime(row, "created_at"),
+	}
+}
+
+func groupMemberFromRow(row map[string]interface{}) *GroupMember {
+	return &GroupMember{
+		ID:        tools.RowUint(row, "id"),
+		GroupID:   tools.RowUint(row, "group_id"),
+		UserID:    tools.RowUint(row, "user_id"),
+		CreatedAt: tools.RowTime(row, "created_at"),
+	}
+}
+
+func groupAuditFromRow(row map[string]interface{}) *GroupAudit {
+	return &GroupAudit{
+		ID:        tools.RowUint(row, "id"),
+		DomainID:  tools.RowUint(row, "domain_id"),
+		GroupID:   tools.RowUint(row, "group_id"),
+		UserID:    tools.RowUint(row, "user_id"),
+		Operation: morm.SafeString(row["operation"]),
+		Actor:     morm.SafeString(row["actor"]),
+		CreatedAt: tools.RowTime(row, "created_at"),
+	}
+}
+
+func rightFromRow(row map[string]interface{}) *Right {
+	return &Right{
+		ID:               tools.RowUint(row, "id"),
+		DomainID:         tools.RowUint(row, "domain_id"),
+		UserID:           tools.RowUint(row, "user_id"),
+		GroupID:          tools.RowUint(row, "group_id"),
+		RoleID:           tools.RowUint(row, "role_id"),
+		ObjectID:         morm.SafeString(row["object_id"]),
+		Deny:             tools.RowBool(row, "deny"),
+		Condition:        morm.SafeString(row["condition"]),
+		ExpiresAt:        morm.SafeTime(row["expires_at"]),
+		CreatedAt:        tools.RowTime(row, "created_at"),
+		ExpiryNotifiedAt: morm.SafeTime(row["expiry_notified_at"]),
+	}
+}
+
+func (mormStore) findDomain(filters map[string]string) (*Domain, error) {
+	row, err := tools.FindOneRow("domain", filters)
+	if err != nil {
+		return nil, err
+	}
+	return domainFromRow(row), nil
+}
+
+func (mormStore) CreateDomain(d *Domain) error { return morm.Create(d) }
+func (mormStore) UpdateDomain(d *Domain) error { return morm.Update(d) }
+
+func (s mormStore) GetDomain(id uint64) (*Domain, error) {
+	return s.findDomain(map[string]string{"id": tools.FormatID(id)})
+}
+
+func (s mormStore) GetDomainByName(name string) (*Domain, error) {
+	return s.findDomain(map[string]string{"name": tools.QuoteValue(name)})
+}
+
+func (s mormStore) GetDomainByTokenHash(hash string) (*Domain, error) {
+	return s.findDomain(map[string]string{"token_hash": tools.QuoteValue(hash)})
+}
+
+func (mormStore) GetDomains() ([]*Domain, error) {
+	rows, err := tools.FindAllRows("domain", map[string]string{"morm_orderby": "name"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Domain, len(rows))
+	for i, row := range rows {
+		result[i] = domainFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateUser(u *User) error { return morm.Create(u) }
+func (mormStore) UpdateUser(u *User) error { return morm.Update(u) }
+func (mormStore) DeleteUser(u *User) error { return morm.Delete(u) }
+
+func (mormStore) GetUser(id uint64) (*User, error) {
+	row, err := tools.FindOneRow("user", map[string]string{"id": tools.FormatID(id)})
+	if err != nil {
+		return nil, err
+	}
+	return userFromRow(row), nil
+}
+
+func (mormStore) GetUserByLogin(domainID uint64, login string) (*User, error) {
+	row, err := tools.FindOneRow("user", map[string]string{"domain_id": tools.FormatID(domainID), "login": tools.QuoteValue(login)})
+	if err != nil {
+		return nil, err
+	}
+	return userFromRow(row), nil
+}
+
+func (mormStore) GetUsers(domainID uint64) ([]*User, error) {
+	rows, err := tools.FindAllRows("user", map[string]string{"domain_id": tools.FormatID(domainID), "morm_orderby": "login"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*User, len(rows))
+	for i, row := range rows {
+		result[i] = userFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateAction(a *Action) error { return morm.Create(a) }
+func (mormStore) DeleteAction(a *Action) error { return morm.Delete(a) }
+
+func (mormStore) GetAction(id uint64) (*Action, error) {
+	row, err := tools.FindOneRow("action", map[string]string{"id": tools.FormatID(id)})
+	if err != nil {
+		return nil, err
+	}
+	return actionFromRow(row), nil
+}
+
+func (mormStore) GetActionByName(domainID uint64, name string) (*Action, error) {
+	row, err := tools.FindOneRow("action", map[string]string{"domain_id": tools.FormatID(domainID), "name": tools.QuoteValue(name)})
+	if err != nil {
+		return nil, err
+	}
+	return actionFromRow(row), nil
+}
+
+func (mormStore) GetActions(domainID uint64) ([]*Action, error) {
+	rows, err := tools.FindAllRows("action", map[string]string{"domain_id": tools.FormatID(domainID), "morm_orderby": "name"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Action, len(rows))
+	for i, row := range rows {
+		result[i] = actionFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateRole(r *Role) error { return morm.Create(r) }
+func (mormStore) UpdateRole(r *Role) error { return morm.Update(r) }
+func (mormStore) DeleteRole(r *Role) error { return morm.Delete(r) }
+
+func (mormStore) GetRole(id uint64) (*Role, error) {
+	row, err := tools.FindOneRow("role", map[string]string{"id": tools.FormatID(id)})
+	if err != nil {
+		return nil, err
+	}
+	return roleFromRow(row), nil
+}
+
+func (mormStore) GetRoleByName(domainID uint64, name string) (*Role, error) {
+	row, err := tools.FindOneRow("role", map[string]string{"domain_id": tools.FormatID(domainID), "name": tools.QuoteValue(name)})
+	if err != nil {
+		return nil, err
+	}
+	return roleFromRow(row), nil
+}
+
+func (mormStore) GetRoles(domainID uint64) ([]*Role, error) {
+	rows, err := tools.FindAllRows("role", map[string]string{"domain_id": tools.FormatID(domainID), "morm_orderby": "name"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Role, len(rows))
+	for i, row := range rows {
+		result[i] = roleFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) findRoleActions(filters map[string]string) ([]*RoleAction, error) {
+	rows, err := tools.FindAllRows("role_action", filters)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*RoleAction, len(rows))
+	for i, row := range rows {
+		result[i] = roleActionFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateRoleAction(ra *RoleAction) error { return morm.Create(ra) }
+func (mormStore) DeleteRoleAction(ra *RoleAction) error { return morm.Delete(ra) }
+
+func (s mormStore) GetRoleActions(roleID uint64) ([]*RoleAction, error) {
+	return s.findRoleActions(map[string]string{"role_id": tools.FormatID(roleID)})
+}
+
+func (s mormStore) GetActionRoles(actionID uint64) ([]*RoleAction, error) {
+	return s.findRoleActions(map[string]string{"action_id": tools.FormatID(actionID)})
+}
+
+func (mormStore) findRoleIncludes(filters map[string]string) ([]*RoleInclude, error) {
+	rows, err := tools.FindAllRows("role_include", filters)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*RoleInclude, len(rows))
+	for i, row := range rows {
+		result[i] = roleIncludeFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateRoleInclude(ri *RoleInclude) error { return morm.Create(ri) }
+func (mormStore) DeleteRoleInclude(ri *RoleInclude) error { return morm.Delete(ri) }
+
+func (s mormStore) GetRoleIncludes(roleID uint64) ([]*RoleInclude, error) {
+	return s.findRoleIncludes(map[string]string{"role_id": tools.FormatID(roleID)})
+}
+
+func (s mormStore) GetIncludingRoles(includedRoleID uint64) ([]*RoleInclude, error) {
+	return s.findRoleIncludes(map[string]string{"included_role_id": tools.FormatID(includedRoleID)})
+}
+
+func (mormStore) findRights(filters map[string]string) ([]*Right, error) {
+	rows, err := tools.FindAllRows("right", filters)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Right, len(rows))
+	for i, row := range rows {
+		result[i] = rightFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateRight(r *Right) error { return morm.Create(r) }
+func (mormStore) UpdateRight(r *Right) error { return morm.Update(r) }
+func (mormStore) DeleteRight(r *Right) error { return morm.Delete(r) }
+
+func (mormStore) GetRight(id uint64) (*Right, error) {
+	row, err := tools.FindOneRow("right", map[string]string{"id": tools.FormatID(id)})
+	if err != nil {
+		return nil, err
+	}
+	return rightFromRow(row), nil
+}
+
+func (s mormStore) GetUserRights(userID uint64) ([]*Right, error) {
+	return s.findRights(map[string]string{"user_id": tools.FormatID(userID)})
+}
+
+func (s mormStore) GetGroupRights(groupID uint64) ([]*Right, error) {
+	return s.findRights(map[string]string{"group_id": tools.FormatID(groupID)})
+}
+
+func (s mormStore) GetRoleRights(roleID uint64) ([]*Right, error) {
+	return s.findRights(map[string]string{"role_id": tools.FormatID(roleID)})
+}
+
+func (s mormStore) GetDomainRights(domainID uint64) ([]*Right, error) {
+	return s.findRights(map[string]string{"domain_id": tools.FormatID(domainID)})
+}
+
+func (s mormStore) GetObjectRights(domainID uint64, objectID string) ([]*Right, error) {
+	return s.findRights(map[string]string{"domain_id": tools.FormatID(domainID), "object_id": tools.QuoteValue(objectID)})
+}
+
+func (mormStore) ArchiveRight(a *RightArchive) error { return morm.Create(a) }
+
+func (mormStore) findGroup(filters map[string]string) (*Group, error) {
+	row, err := tools.FindOneRow("group", filters)
+	if err != nil {
+		return nil, err
+	}
+	return groupFromRow(row), nil
+}
+
+func (mormStore) CreateGroup(g *Group) error { return morm.Create(g) }
+func (mormStore) DeleteGroup(g *Group) error { return morm.Delete(g) }
+
+func (s mormStore) GetGroup(id uint64) (*Group, error) {
+	return s.findGroup(map[string]string{"id": tools.FormatID(id)})
+}
+
+func (s mormStore) GetGroupByName(domainID uint64, name string) (*Group, error) {
+	return s.findGroup(map[string]string{"domain_id": tools.FormatID(domainID), "name": tools.QuoteValue(name)})
+}
+
+func (mormStore) GetGroups(domainID uint64) ([]*Group, error) {
+	rows, err := tools.FindAllRows("group", map[string]string{"domain_id": tools.FormatID(domainID), "morm_orderby": "name"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Group, len(rows))
+	for i, row := range rows {
+		result[i] = groupFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) findGroupMembers(filters map[string]string) ([]*GroupMember, error) {
+	rows, err := tools.FindAllRows("group_member", filters)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*GroupMember, len(rows))
+	for i, row := range rows {
+		result[i] = groupMemberFromRow(row)
+	}
+	return result, nil
+}
+
+func (mormStore) CreateGroupMember(m *GroupMember) error { return morm.Create(m) }
+func (mormStore) DeleteGroupMember(m *GroupMember) error { return morm.Delete(m) }
+
+func (s mormStore) GetGroupMembers(groupID uint64) ([]*GroupMember, error) {
+	return s.findGroupMembers(map[string]string{"group_id": tools.FormatID(groupID)})
+}
+
+func (s mormStore) GetUserGroups(userID uint64) ([]*GroupMember, error) {
+	return s.findGroupMembers(map[string]string{"user_id": tools.FormatID(userID)})
+}
+
+func (mormStore) CreateGroupAudit(a *GroupAudit) error { return morm.Create(a) }
+
+func (mormStore) GetGroupAudit(groupID uint64) ([]*GroupAudit, error) {
+	rows, err := tools.FindAllRows("group_audit", map[string]string{"group_id": tools.FormatID(groupID), "morm_orderby": "id"})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*GroupAudit, len(rows))
+	for i, row := range rows {
+		result[i] = groupAuditFromRow(row)
+	}
+	return result, nil
+}
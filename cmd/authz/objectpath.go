@@ -0,0 +1,174 @@
+package authz
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**** object paths
+
+An object path describes an object through the tree of its ancestors :
+	company(9)garage(28)car(123)
+Each segment is made of an object type followed by the object id between parenthesis.
+Types and ids may contain any character, '(', ')' and '\' having to be escaped with a '\'.
+The type cannot be empty but the id may be : company() is valid.
+An id made of a single unescaped '*' is a wildcard matching any id of the type :
+a right on company(9)garage(*) applies to every garage of company(9). An id made of
+an escaped '\*' is a plain star. Wildcards are only allowed in the paths of rights,
+a checked object being a single object.
+*/
+
+//MaxObjectPathLength is the maximum length in bytes of an object path, the size of the object_id columns
+const MaxObjectPathLength = 512
+
+//Segment is one element of an object path : type(id), or type(*) when Any is set
+type Segment struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Any  bool   `json:"any,omitempty"`
+}
+
+//ObjectPath is a parsed object_id, from the root to the object
+type ObjectPath []Segment
+
+//PathError describes why an object path could not be parsed
+type PathError struct {
+	Path string
+	Pos  int
+	Msg  string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid object path %q at position %d : %s", e.Path, e.Pos, e.Msg)
+}
+
+//wildcardMsg is the message of the PathError returned for a wildcard in a checked object
+const wildcardMsg = "wildcard ids are only allowed in rights"
+
+//ParseObjectPath parses an object path such as company(9)garage(28)car(123)
+func ParseObjectPath(s string) (ObjectPath, error) {
+	return parseObjectPath(s, true)
+}
+
+//ParseCheckedPath parses the path of a checked object, refusing wildcard ids
+func ParseCheckedPath(s string) (ObjectPath, error) {
+	return parseObjectPath(s, false)
+}
+
+//isWildcardError tells if err was returned for a wildcard in a checked object
+func isWildcardError(err error) bool {
+	pe, ok := err.(*PathError)
+	return ok && pe.Msg == wildcardMsg
+}
+
+func parseObjectPath(s string, wildcards bool) (ObjectPath, error) {
+	if s == "" {
+		return nil, &PathError{Path: s, Pos: 0, Msg: "empty path"}
+	}
+	if len(s) > MaxObjectPathLength {
+		return nil, &PathError{Path: s, Pos: MaxObjectPathLength, Msg: fmt.Sprintf("longer than %d bytes", MaxObjectPathLength)}
+	}
+	result := ObjectPath{}
+	var current strings.Builder
+	var seg Segment
+	inID := false
+	escaped := false //the id being read holds an escaped character
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 >= len(s) {
+				return nil, &PathError{Path: s, Pos: i, Msg: "dangling escape character"}
+			}
+			i++
+			escaped = escaped || inID
+			current.WriteByte(s[i])
+		case '(':
+			if inID {
+				return nil, &PathError{Path: s, Pos: i, Msg: "unescaped '(' inside an id"}
+			}
+			if current.Len() == 0 {
+				return nil, &PathError{Path: s, Pos: i, Msg: "missing object type"}
+			}
+			seg.Type = current.String()
+			current.Reset()
+			inID = true
+		case ')':
+			if !inID {
+				return nil, &PathError{Path: s, Pos: i, Msg: "unexpected ')'"}
+			}
+			seg.ID = current.String()
+			seg.Any = seg.ID == "*" && !escaped
+			if seg.Any && !wildcards {
+				return nil, &PathError{Path: s, Pos: i - 1, Msg: wildcardMsg}
+			}
+			current.Reset()
+			result = append(result, seg)
+			seg = Segment{}
+			inID = false
+			escaped = false
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if inID {
+		return nil, &PathError{Path: s, Pos: len(s), Msg: "missing ')'"}
+	}
+	if current.Len() > 0 {
+		return nil, &PathError{Path: s, Pos: len(s) - current.Len(), Msg: "object type without id"}
+	}
+	return result, nil
+}
+
+//escapePathElement escapes the special characters of a type or an id
+func escapePathElement(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `(`, `\(`)
+	return strings.ReplaceAll(s, `)`, `\)`)
+}
+
+//String returns the canonical form of a segment
+func (s Segment) String() string {
+	switch {
+	case s.Any:
+		return escapePathElement(s.Type) + "(*)"
+	case s.ID == "*":
+		return escapePathElement(s.Type) + `(\*)`
+	}
+	return escapePathElement(s.Type) + "(" + escapePathElement(s.ID) + ")"
+}
+
+//Matches tells if a segment of a right matches a segment of a checked path.
+//A wildcard matches any id of its type, including a wildcard
+func (s Segment) Matches(other Segment) bool {
+	return s.Type == other.Type && (s.Any || (!other.Any && s.ID == other.ID))
+}
+
+//Pattern returns the wildcard segment of the type of the segment
+func (s Segment) Pattern() Segment {
+	return Segment{Type: s.Type, ID: "*", Any: true}
+}
+
+//String returns the canonical form of the path
+func (p ObjectPath) String() string {
+	var b strings.Builder
+	for _, s := range p {
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+//EndsWith tells if the last segments of the path are matched by the ones of the suffix.
+//A right given on garage(28) applies to company(9)garage(28), as a right given on garage(*)
+func (p ObjectPath) EndsWith(suffix ObjectPath) bool {
+	if len(suffix) == 0 || len(suffix) > len(p) {
+		return false
+	}
+	offset := len(p) - len(suffix)
+	for i, s := range suffix {
+		if !s.Matches(p[offset+i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+package authz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseObjectPath(t *testing.T) {
+	tests := []struct {
+		path      string
+		want      ObjectPath
+		canonical string
+	}{
+		//nesting
+		{`car(123)`, ObjectPath{{Type: "car", ID: "123"}}, `car(123)`},
+		{`company(9)garage(28)car(123)`,
+			ObjectPath{{Type: "company", ID: "9"}, {Type: "garage", ID: "28"}, {Type: "car", ID: "123"}},
+			`company(9)garage(28)car(123)`},
+
+		//empty ids
+		{`company()`, ObjectPath{{Type: "company"}}, `company()`},
+		{`company()garage()`, ObjectPath{{Type: "company"}, {Type: "garage"}}, `company()garage()`},
+
+		//escaping
+		{`car(a\(b\)c)`, ObjectPath{{Type: "car", ID: "a(b)c"}}, `car(a\(b\)c)`},
+		{`car(a\\b)`, ObjectPath{{Type: "car", ID: `a\b`}}, `car(a\\b)`},
+		{`c\(ar(1)`, ObjectPath{{Type: "c(ar", ID: "1"}}, `c\(ar(1)`},
+		{`car(\x)`, ObjectPath{{Type: "car", ID: "x"}}, `car(x)`},
+		{`car(a b)`, ObjectPath{{Type: "car", ID: "a b"}}, `car(a b)`},
+
+		//wildcards
+		{`company(9)garage(*)`, ObjectPath{{Type: "company", ID: "9"}, {Type: "garage", ID: "*", Any: true}}, `company(9)garage(*)`},
+		{`car(\*)`, ObjectPath{{Type: "car", ID: "*"}}, `car(\*)`},
+		{`car(**)`, ObjectPath{{Type: "car", ID: "**"}}, `car(**)`},
+		{`car(a*)`, ObjectPath{{Type: "car", ID: "a*"}}, `car(a*)`},
+	}
+	for _, test := range tests {
+		got, err := ParseObjectPath(test.path)
+		if err != nil {
+			t.Errorf("ParseObjectPath(%s) : unexpected error %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseObjectPath(%s) = %#v, want %#v", test.path, got, test.want)
+		}
+		if s := got.String(); s != test.canonical {
+			t.Errorf("ParseObjectPath(%s).String() = %s, want %s", test.path, s, test.canonical)
+		}
+	}
+}
+
+func TestParseObjectPathErrors(t *testing.T) {
+	tests := []struct {
+		path string
+		pos  int
+		msg  string
+	}{
+		{``, 0, "empty path"},
+		{`car`, 0, "object type without id"},
+		{`car(1)garage`, 6, "object type without id"},
+		{`(1)`, 0, "missing object type"},
+		{`car(1)(2)`, 6, "missing object type"},
+		{`car(1`, 5, "missing ')'"},
+		{`car(1))`, 6, "unexpected ')'"},
+		{`)`, 0, "unexpected ')'"},
+		{`car(a(b))`, 5, "unescaped '(' inside an id"},
+		{`car(1)\`, 6, "dangling escape character"},
+		{`car(1\`, 5, "dangling escape character"},
+	}
+	for _, test := range tests {
+		_, err := ParseObjectPath(test.path)
+		checkPathError(t, test.path, err, test.pos, test.msg)
+	}
+}
+
+func TestParseCheckedPath(t *testing.T) {
+	for _, path := range []string{`car(123)`, `car(\*)`, `car(**)`, `c*(1)`} {
+		if _, err := ParseCheckedPath(path); err != nil {
+			t.Errorf("ParseCheckedPath(%s) : unexpected error %v", path, err)
+		}
+	}
+	_, err := ParseCheckedPath(`company(9)garage(*)car(1)`)
+	checkPathError(t, "wildcard", err, 17, wildcardMsg)
+	if !isWildcardError(err) {
+		t.Errorf("isWildcardError(%v) = false", err)
+	}
+	_, err = ParseCheckedPath(`car(*`)
+	checkPathError(t, "malformed wildcard", err, 5, "missing ')'")
+	if isWildcardError(err) {
+		t.Errorf("isWildcardError(%v) = true", err)
+	}
+}
+
+func TestObjectPathLength(t *testing.T) {
+	//car(...) : 5 bytes around the id
+	longest := "car(" + strings.Repeat("x", MaxObjectPathLength-5) + ")"
+	if _, err := ParseObjectPath(longest); err != nil {
+		t.Errorf("ParseObjectPath of %d bytes : unexpected error %v", len(longest), err)
+	}
+	tooLong := "car(" + strings.Repeat("x", MaxObjectPathLength-4) + ")"
+	_, err := ParseObjectPath(tooLong)
+	checkPathError(t, "long path", err, MaxObjectPathLength, "longer than")
+}
+
+func TestObjectPathRoundTrip(t *testing.T) {
+	paths := []ObjectPath{
+		{{Type: "company", ID: "9"}, {Type: "garage", ID: "28"}},
+		{{Type: "a(b", ID: `c)d\e`}},
+		{{Type: "car", ID: "*"}},
+		{{Type: "car", ID: "*", Any: true}},
+		{Segment{Type: "car", ID: "x"}.Pattern()},
+		{{Type: "car", ID: ""}, {Type: "door", ID: "()"}},
+		{{Type: "x", ID: `\*`}},
+	}
+	for _, path := range paths {
+		s := path.String()
+		got, err := ParseObjectPath(s)
+		if err != nil {
+			t.Errorf("ParseObjectPath(%s) : unexpected error %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, path) {
+			t.Errorf("ParseObjectPath(%s) = %#v, want %#v", s, got, path)
+		}
+	}
+}
+
+func TestObjectPathEndsWith(t *testing.T) {
+	tests := []struct {
+		path, suffix string
+		want         bool
+	}{
+		{`company(9)garage(28)car(123)`, `car(123)`, true},
+		{`company(9)garage(28)car(123)`, `garage(28)car(123)`, true},
+		{`company(9)garage(28)car(123)`, `company(9)garage(28)car(123)`, true},
+		{`company(9)garage(28)car(123)`, `garage(28)`, false},
+		{`company(9)garage(28)car(123)`, `car(124)`, false},
+		{`company(9)garage(28)car(123)`, `garage(*)car(123)`, true},
+		{`company(9)garage(28)car(123)`, `company(*)`, false},
+		{`car(123)`, `company(9)car(123)`, false},
+		{`car(*)`, `car(123)`, false},
+		{`car(\*)`, `car(*)`, true},
+	}
+	for _, test := range tests {
+		path, _ := ParseObjectPath(test.path)
+		suffix, _ := ParseObjectPath(test.suffix)
+		if got := path.EndsWith(suffix); got != test.want {
+			t.Errorf("%s EndsWith %s = %v, want %v", test.path, test.suffix, got, test.want)
+		}
+	}
+}
+
+//checkPathError fails the test unless err is a *PathError at pos whose message contains msg
+func checkPathError(t *testing.T, path string, err error, pos int, msg string) {
+	t.Helper()
+	pe, ok := err.(*PathError)
+	if !ok {
+		t.Errorf("%s : got error %v, want a *PathError", path, err)
+		return
+	}
+	if pe.Pos != pos || !strings.Contains(pe.Msg, msg) {
+		t.Errorf("%s : got error at %d %q, want at %d %q", path, pe.Pos, pe.Msg, pos, msg)
+	}
+}
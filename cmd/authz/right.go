@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"strings"
+	"time"
+)
+
+//Right assigns a role to a user, or to the members of a group, for a specific object, with an
+//optional expiration date. Group rights have a GroupID and no UserID.
+//A Deny right withdraws the actions of the role on the object and its descendants instead.
+//A granted right with a Condition only applies when the condition holds for the attributes of the check.
+//ExpiryNotifiedAt is set once EventRightExpiring has been published for the right
+type Right struct {
+	ID               uint64     `db:"id" json:"id"`
+	DomainID         uint64     `db:"domain_id" json:"domain_id"`
+	UserID           uint64     `db:"user_id" json:"user_id"`
+	GroupID          uint64     `db:"group_id" json:"group_id,omitempty"`
+	RoleID           uint64     `db:"role_id" json:"role_id"`
+	ObjectID         string     `db:"object_id" json:"object_id"`
+	Deny             bool       `db:"deny" json:"deny"`
+	Condition        string     `db:"condition" json:"condition,omitempty"`
+	ExpiresAt        *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	ExpiryNotifiedAt *time.Time `db:"expiry_notified_at" json:"-"`
+}
+
+//GrantRight gives a role to a user on an object. expiresAt may be nil for a permanent right
+func GrantRight(userID uint64, roleID uint64, objectID string, expiresAt *time.Time) (*Right, error) {
+	return createRight(Right{UserID: userID}, roleID, objectID, expiresAt)
+}
+
+//GrantConditionalRight gives a role to a user on an object, applying only when the condition holds
+func GrantConditionalRight(userID uint64, roleID uint64, objectID string, condition string, expiresAt *time.Time) (*Right, error) {
+	return createRight(Right{UserID: userID, Condition: condition}, roleID, objectID, expiresAt)
+}
+
+//DenyRight withdraws the actions of a role from a user on an object and its descendants,
+//overriding the rights granted on the object, its ancestors and its descendants. expiresAt may be nil for a permanent deny
+func DenyRight(userID uint64, roleID uint64, objectID string, expiresAt *time.Time) (*Right, error) {
+	return createRight(Right{UserID: userID, Deny: true}, roleID, objectID, expiresAt)
+}
+
+//GrantGroupRight gives a role to every member of a group on an object
+func GrantGroupRight(groupID uint64, roleID uint64, objectID string, expiresAt *time.Time) (*Right, error) {
+	return createRight(Right{GroupID: groupID}, roleID, objectID, expiresAt)
+}
+
+//GrantConditionalGroupRight gives a role to every member of a group on an object, applying only when the condition holds
+func GrantConditionalGroupRight(groupID uint64, roleID uint64, objectID string, condition string, expiresAt *time.Time) (*Right, error) {
+	return createRight(Right{GroupID: groupID, Condition: condition}, roleID, objectID, expiresAt)
+}
+
+//DenyGroupRight withdraws the actions of a role from every member of a group on an object and its descendants
+func DenyGroupRight(groupID uint64, roleID uint64, objectID string, expiresAt *time.Time) (*Right, error) {
+	return createRight(Right{GroupID: groupID, Deny: true}, roleID, objectID, expiresAt)
+}
+
+//createRight completes and stores a right held by r.UserID or r.GroupID
+func createRight(r Right, roleID uint64, objectID string, expiresAt *time.Time) (*Right, error) {
+	path, err := ParseObjectPath(strings.TrimSpace(objectID))
+	if err != nil {
+		return nil, err
+	}
+	if r.Condition = strings.TrimSpace(r.Condition); r.Condition != "" {
+		if _, err := ParseCondition(r.Condition); err != nil {
+			return nil, err
+		}
+	}
+	if r.GroupID != 0 {
+		g, err := GetGroupByID(r.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		r.DomainID = g.DomainID
+	} else {
+		u, err := GetUserByID(r.UserID)
+		if err != nil {
+			return nil, err
+		}
+		r.DomainID = u.DomainID
+	}
+	role, err := GetRoleByID(roleID)
+	if err != nil {
+		return nil, err
+	}
+	if r.DomainID != role.DomainID {
+		return nil, ErrDomainMismatch
+	}
+	r.RoleID = role.ID
+	r.ObjectID = path.String()
+	r.ExpiresAt = expiresAt
+	r.CreatedAt = time.Now().UTC()
+	if err := store.CreateRight(&r); err != nil {
+		return nil, err
+	}
+	publish(EventRightGranted, &r)
+	return &r, nil
+}
+
+//GetRightByID returns a right or ErrNotFound
+func GetRightByID(id uint64) (*Right, error) {
+	return store.GetRight(id)
+}
+
+//GetUserRights returns every right granted to a user, expired or not.
+//The rights of their groups are not included
+func GetUserRights(userID uint64) ([]*Right, error) {
+	return store.GetUserRights(userID)
+}
+
+//GetDomainRights returns every right of a domain
+func GetDomainRights(domainID uint64) ([]*Right, error) {
+	return store.GetDomainRights(domainID)
+}
+
+//GetObjectRights returns every right granted on an object of a domain
+func GetObjectRights(domainID uint64, objectID string) ([]*Right, error) {
+	path, err := ParseObjectPath(objectID)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetObjectRights(domainID, path.String())
+}
+
+//Expired tells if the right is expired at a given time
+func (r *Right) Expired(at time.Time) bool {
+	return r.ExpiresAt != nil && !r.ExpiresAt.After(at)
+}
+
+//Revoke removes the right
+func (r *Right) Revoke() error {
+	if err := store.DeleteRight(r); err != nil {
+		return err
+	}
+	publish(EventRightRevoked, r)
+	return nil
+}
@@ -0,0 +1,381 @@
+package authz
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+//Action is an atomic task whose access has to be verified
+type Action struct {
+	ID          uint64 `db:"id" json:"id"`
+	DomainID    uint64 `db:"domain_id" json:"domain_id"`
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+}
+
+//Role gathers actions. It is granted to users through Rights.
+//Users holding a role with RequireMFA must authenticate with a second factor
+type Role struct {
+	ID          uint64 `db:"id" json:"id"`
+	DomainID    uint64 `db:"domain_id" json:"domain_id"`
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	RequireMFA  bool   `db:"require_mfa" json:"require_mfa"`
+}
+
+//RoleAction links an action to a role
+type RoleAction struct {
+	ID       uint64 `db:"id" json:"id"`
+	RoleID   uint64 `db:"role_id" json:"role_id"`
+	ActionID uint64 `db:"action_id" json:"action_id"`
+}
+
+//RoleInclude makes a role include every action of another role of the same domain.
+//Includes are transitive and never form a cycle
+type RoleInclude struct {
+	ID             uint64 `db:"id" json:"id"`
+	RoleID         uint64 `db:"role_id" json:"role_id"`
+	IncludedRoleID uint64 `db:"included_role_id" json:"included_role_id"`
+}
+
+//includeMutex serializes the cycle detection and the creation of includes
+var includeMutex sync.Mutex
+
+//CreateAction creates an action in a domain
+func CreateAction(domainID uint64, name string, description string) (*Action, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+	if _, err := GetDomainByID(domainID); err != nil {
+		return nil, err
+	}
+	if _, err := GetActionByName(domainID, name); err != ErrNotFound {
+		if err == nil {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+	a := Action{DomainID: domainID, Name: name, Description: description}
+	if err := store.CreateAction(&a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+//GetActionByID returns an action or ErrNotFound
+func GetActionByID(id uint64) (*Action, error) {
+	return store.GetAction(id)
+}
+
+//GetActionByName returns the action of the domain with this name or ErrNotFound
+func GetActionByName(domainID uint64, name string) (*Action, error) {
+	return store.GetActionByName(domainID, name)
+}
+
+//GetActions returns every action of a domain
+func GetActions(domainID uint64) ([]*Action, error) {
+	return store.GetActions(domainID)
+}
+
+//Delete removes the action and unlinks it from every role
+func (a *Action) Delete() error {
+	links, err := store.GetActionRoles(a.ID)
+	if err != nil {
+		return err
+	}
+	for _, ra := range links {
+		if err := store.DeleteRoleAction(ra); err != nil {
+			return err
+		}
+	}
+	if err := store.DeleteAction(a); err != nil {
+		return err
+	}
+	publish(EventActionDeleted, a)
+	return nil
+}
+
+//CreateRole creates a role in a domain
+func CreateRole(domainID uint64, name string, description string) (*Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+	if _, err := GetDomainByID(domainID); err != nil {
+		return nil, err
+	}
+	if _, err := GetRoleByName(domainID, name); err != ErrNotFound {
+		if err == nil {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+	r := Role{DomainID: domainID, Name: name, Description: description}
+	if err := store.CreateRole(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+//GetRoleByID returns a role or ErrNotFound
+func GetRoleByID(id uint64) (*Role, error) {
+	return store.GetRole(id)
+}
+
+//GetRoleByName returns the role of the domain with this name or ErrNotFound
+func GetRoleByName(domainID uint64, name string) (*Role, error) {
+	return store.GetRoleByName(domainID, name)
+}
+
+//GetRoles returns every role of a domain
+func GetRoles(domainID uint64) ([]*Role, error) {
+	return store.GetRoles(domainID)
+}
+
+//SetRequireMFA tells whether the users holding the role must authenticate with a second factor
+func (r *Role) SetRequireMFA(require bool) error {
+	r.RequireMFA = require
+	return store.UpdateRole(r)
+}
+
+//UserRequiresMFA tells if the user, or one of their groups, is granted an unexpired right on a role
+//requiring a second factor, directly or through an included role. Deny rights give nothing and are ignored
+func UserRequiresMFA(userID uint64) (bool, error) {
+	rights, err := getHolderRights(userID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	checked := map[uint64]bool{}
+	for _, right := range rights {
+		if right.Deny || right.Expired(now) || checked[right.RoleID] {
+			continue
+		}
+		checked[right.RoleID] = true
+		roles, err := flattenRole(right.RoleID)
+		if err != nil {
+			return false, err
+		}
+		for _, role := range roles {
+			if role.RequireMFA {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+//IncludeRole makes the role include another role of the same domain.
+//ErrRoleCycle is returned when the other role already includes this one, even indirectly
+func (r *Role) IncludeRole(other *Role) error {
+	if r.DomainID != other.DomainID {
+		return ErrDomainMismatch
+	}
+	includeMutex.Lock()
+	defer includeMutex.Unlock()
+	if _, err := r.findRoleInclude(other.ID); err != ErrNotFound {
+		if err == nil {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	included, err := flattenRole(other.ID)
+	if err != nil {
+		return err
+	}
+	for _, role := range included {
+		if role.ID == r.ID {
+			return ErrRoleCycle
+		}
+	}
+	ri := RoleInclude{RoleID: r.ID, IncludedRoleID: other.ID}
+	if err := store.CreateRoleInclude(&ri); err != nil {
+		return err
+	}
+	publish(EventRoleIncludeAdded, &ri)
+	return nil
+}
+
+//ExcludeRole removes an include made by IncludeRole
+func (r *Role) ExcludeRole(other *Role) error {
+	ri, err := r.findRoleInclude(other.ID)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteRoleInclude(ri); err != nil {
+		return err
+	}
+	publish(EventRoleIncludeRemoved, ri)
+	return nil
+}
+
+//findRoleInclude returns the include of another role by the role or ErrNotFound
+func (r *Role) findRoleInclude(includedRoleID uint64) (*RoleInclude, error) {
+	includes, err := store.GetRoleIncludes(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ri := range includes {
+		if ri.IncludedRoleID == includedRoleID {
+			return ri, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+//GetIncludedRoles returns the roles directly included by the role
+func (r *Role) GetIncludedRoles() ([]*Role, error) {
+	includes, err := store.GetRoleIncludes(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Role, 0, len(includes))
+	for _, ri := range includes {
+		role, err := GetRoleByID(ri.IncludedRoleID)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, role)
+	}
+	return result, nil
+}
+
+//flattenRole returns the role followed by every role it includes, directly or not, breadth first
+func flattenRole(roleID uint64) ([]*Role, error) {
+	result := []*Role{}
+	seen := map[uint64]bool{roleID: true}
+	queue := []uint64{roleID}
+	for len(queue) > 0 {
+		role, err := GetRoleByID(queue[0])
+		queue = queue[1:]
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, role)
+		includes, err := store.GetRoleIncludes(role.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ri := range includes {
+			if !seen[ri.IncludedRoleID] {
+				seen[ri.IncludedRoleID] = true
+				queue = append(queue, ri.IncludedRoleID)
+			}
+		}
+	}
+	return result, nil
+}
+
+//findRoleAction returns the link between the role and an action or ErrNotFound
+func (r *Role) findRoleAction(actionID uint64) (*RoleAction, error) {
+	links, err := store.GetRoleActions(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ra := range links {
+		if ra.ActionID == actionID {
+			return ra, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+//AddAction links an action to the role. Both have to belong to the same domain
+func (r *Role) AddAction(a *Action) error {
+	if r.DomainID != a.DomainID {
+		return ErrDomainMismatch
+	}
+	if _, err := r.findRoleAction(a.ID); err != ErrNotFound {
+		if err == nil {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	ra := RoleAction{RoleID: r.ID, ActionID: a.ID}
+	if err := store.CreateRoleAction(&ra); err != nil {
+		return err
+	}
+	publish(EventRoleActionAdded, &ra)
+	return nil
+}
+
+//RemoveAction unlinks an action from the role
+func (r *Role) RemoveAction(a *Action) error {
+	ra, err := r.findRoleAction(a.ID)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteRoleAction(ra); err != nil {
+		return err
+	}
+	publish(EventRoleActionRemoved, ra)
+	return nil
+}
+
+//GetActions returns the actions of the role
+func (r *Role) GetActions() ([]*Action, error) {
+	links, err := store.GetRoleActions(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Action, 0, len(links))
+	for _, ra := range links {
+		a, err := GetActionByID(ra.ActionID)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+//Delete removes the role, its action links, its includes and the rights granting it
+func (r *Role) Delete() error {
+	links, err := store.GetRoleActions(r.ID)
+	if err != nil {
+		return err
+	}
+	for _, ra := range links {
+		if err := store.DeleteRoleAction(ra); err != nil {
+			return err
+		}
+	}
+	includes, err := store.GetRoleIncludes(r.ID)
+	if err != nil {
+		return err
+	}
+	including, err := store.GetIncludingRoles(r.ID)
+	if err != nil {
+		return err
+	}
+	for _, ri := range append(includes, including...) {
+		if err := store.DeleteRoleInclude(ri); err != nil {
+			return err
+		}
+	}
+	rights, err := store.GetRoleRights(r.ID)
+	if err != nil {
+		return err
+	}
+	for _, right := range rights {
+		if err := right.Revoke(); err != nil {
+			return err
+		}
+	}
+	if err := store.DeleteRole(r); err != nil {
+		return err
+	}
+	publish(EventRoleDeleted, r)
+	return nil
+}
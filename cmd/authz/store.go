@@ -0,0 +1,100 @@
+package authz
+
+import (
+	"strings"
+)
+
+//Store is the permanent storage of the authorization model.
+//Getters return ErrNotFound when nothing matches, lists are returned sorted by name or login
+type Store interface {
+	CreateDomain(d *Domain) error
+	UpdateDomain(d *Domain) error
+	GetDomain(id uint64) (*Domain, error)
+	GetDomainByName(name string) (*Domain, error)
+	GetDomainByTokenHash(hash string) (*Domain, error)
+	GetDomains() ([]*Domain, error)
+
+	CreateUser(u *User) error
+	UpdateUser(u *User) error
+	DeleteUser(u *User) error
+	GetUser(id uint64) (*User, error)
+	GetUserByLogin(domainID uint64, login string) (*User, error)
+	GetUsers(domainID uint64) ([]*User, error)
+
+	CreateAction(a *Action) error
+	DeleteAction(a *Action) error
+	GetAction(id uint64) (*Action, error)
+	GetActionByName(domainID uint64, name string) (*Action, error)
+	GetActions(domainID uint64) ([]*Action, error)
+
+	CreateRole(r *Role) error
+	UpdateRole(r *Role) error
+	DeleteRole(r *Role) error
+	GetRole(id uint64) (*Role, error)
+	GetRoleByName(domainID uint64, name string) (*Role, error)
+	GetRoles(domainID uint64) ([]*Role, error)
+
+	CreateRoleAction(ra *RoleAction) error
+	DeleteRoleAction(ra *RoleAction) error
+	GetRoleActions(roleID uint64) ([]*RoleAction, error)
+	GetActionRoles(actionID uint64) ([]*RoleAction, error)
+
+	CreateRoleInclude(ri *RoleInclude) error
+	DeleteRoleInclude(ri *RoleInclude) error
+	GetRoleIncludes(roleID uint64) ([]*RoleInclude, error)
+	GetIncludingRoles(includedRoleID uint64) ([]*RoleInclude, error)
+
+	CreateRight(r *Right) error
+	UpdateRight(r *Right) error
+	DeleteRight(r *Right) error
+	GetRight(id uint64) (*Right, error)
+	GetUserRights(userID uint64) ([]*Right, error)
+	GetGroupRights(groupID uint64) ([]*Right, error)
+	GetRoleRights(roleID uint64) ([]*Right, error)
+	GetDomainRights(domainID uint64) ([]*Right, error)
+	GetObjectRights(domainID uint64, objectID string) ([]*Right, error)
+	ArchiveRight(a *RightArchive) error
+
+	CreateGroup(g *Group) error
+	DeleteGroup(g *Group) error
+	GetGroup(id uint64) (*Group, error)
+	GetGroupByName(domainID uint64, name string) (*Group, error)
+	GetGroups(domainID uint64) ([]*Group, error)
+
+	CreateGroupMember(m *GroupMember) error
+	DeleteGroupMember(m *GroupMember) error
+	GetGroupMembers(groupID uint64) ([]*GroupMember, error)
+	GetUserGroups(userID uint64) ([]*GroupMember, error)
+
+	//the audit trail is sorted by ID, oldest first
+	CreateGroupAudit(a *GroupAudit) error
+	GetGroupAudit(groupID uint64) ([]*GroupAudit, error)
+}
+
+//fileScheme prefixes the data sources of flat file stores : file:///var/lib/mauth/mauth.json
+const fileScheme = "file://"
+
+//store is the storage used by the package, SQL through morm by default
+var store Store = mormStore{}
+
+//IsFileDataSource tells if a data source designates a flat file store
+func IsFileDataSource(dataSource string) bool {
+	return strings.HasPrefix(dataSource, fileScheme)
+}
+
+//NewStore returns the store matching a data source :
+//file://path for a JSON flat file, an SQL data source handled by morm otherwise
+func NewStore(dataSource string) (Store, error) {
+	if IsFileDataSource(dataSource) {
+		return NewFileStore(strings.TrimPrefix(dataSource, fileScheme))
+	}
+	return mormStore{}, nil
+}
+
+//SetStore changes the storage used by the package
+func SetStore(s Store) {
+	store = s
+	if cache != nil {
+		cache.Purge()
+	}
+}
@@ -0,0 +1,97 @@
+package authz
+
+import (
+	"strings"
+	"time"
+)
+
+//User is a user of a domain. Logins are unique among a domain.
+//Users who registered by themselves are pending until they verify their email
+type User struct {
+	ID                    uint64     `db:"id" json:"id"`
+	DomainID              uint64     `db:"domain_id" json:"domain_id"`
+	Login                 string     `db:"login" json:"login"`
+	Email                 string     `db:"email" json:"email"`
+	Password              string     `db:"password" json:"-"`
+	CreatedAt             time.Time  `db:"created_at" json:"created_at"`
+	VerificationPending   bool       `db:"verification_pending" json:"verification_pending"`
+	VerificationTokenHash string     `db:"verification_token_hash" json:"-"`
+	VerificationExpiresAt *time.Time `db:"verification_expires_at" json:"-"`
+}
+
+//CreateUser creates a user in a domain
+func CreateUser(domainID uint64, login string, email string) (*User, error) {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return nil, ErrEmptyName
+	}
+	if _, err := GetDomainByID(domainID); err != nil {
+		return nil, err
+	}
+	if _, err := GetUserByLogin(domainID, login); err != ErrNotFound {
+		if err == nil {
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
+	}
+	u := User{DomainID: domainID, Login: login, Email: strings.TrimSpace(email), CreatedAt: time.Now().UTC()}
+	if err := store.CreateUser(&u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+//GetUserByID returns a user or ErrNotFound
+func GetUserByID(id uint64) (*User, error) {
+	return store.GetUser(id)
+}
+
+//GetUserByLogin returns the user of the domain with this login or ErrNotFound
+func GetUserByLogin(domainID uint64, login string) (*User, error) {
+	return store.GetUserByLogin(domainID, login)
+}
+
+//GetUsers returns every user of a domain
+func GetUsers(domainID uint64) ([]*User, error) {
+	return store.GetUsers(domainID)
+}
+
+//Save stores the modifications made on the user
+func (u *User) Save() error {
+	if other, err := GetUserByLogin(u.DomainID, u.Login); err == nil && other.ID != u.ID {
+		return ErrAlreadyExists
+	}
+	return store.UpdateUser(u)
+}
+
+//Delete removes the user along with their rights and their group memberships
+func (u *User) Delete() error {
+	rights, err := GetUserRights(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, r := range rights {
+		if err := r.Revoke(); err != nil {
+			return err
+		}
+	}
+	memberships, err := store.GetUserGroups(u.ID)
+	if err != nil {
+		return err
+	}
+	for _, m := range memberships {
+		if err := store.DeleteGroupMember(m); err != nil {
+			return err
+		}
+		if g, err := GetGroupByID(m.GroupID); err == nil {
+			if err := g.audit(GroupAuditMemberRemoved, u.ID, "user deletion"); err != nil {
+				return err
+			}
+		}
+	}
+	if err := store.DeleteUser(u); err != nil {
+		return err
+	}
+	publish(EventUserDeleted, u)
+	return nil
+}
@@ -1,66 +1,496 @@
 package dispatcher
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+/**** delivery
+
+Every subscription owns a queue and a goroutine delivering its events one at a time, in the order
+they were published. When a subscriber is too slow and its queue is full, the overflow policy of
+the subscription applies : the publisher waits (Block), the oldest queued event is dropped
+(DropOldest) or the published event is dropped (DropNewest). Dropped events are counted.
+
+A panicking callback does not stop the process : the panic is recovered and reported to the error
+hook of the channel along with its stack, and the delivery goes on with the next event.
+Callbacks subscribed with SubscribeErr may return an error instead, in which case the delivery of
+the event is retried with an exponential backoff, up to Retries times, before reporting the error.
+The next events of the subscription wait meanwhile, to keep the order.
+
+PublishSync waits for the callbacks to have handled the event, Drain for every queue to be empty.
+Close stops accepting events and waits for the queued ones to be delivered : Publish then does
+nothing, and PublishSync returns ErrClosed.
+*/
+
+//ErrClosed is returned when publishing to a closed channel
+var ErrClosed = errors.New("Channel is closed")
+
 //Callback is the function prototype Subscribers have to implement
 type Callback func(eventname string, p interface{})
 
+//ErrorCallback is a callback whose failures are retried
+type ErrorCallback func(eventname string, p interface{}) error
+
+//CallbackError reports a callback which panicked, or kept failing after its retries
+type CallbackError struct {
+	EventName string
+	Payload   interface{}
+	Err       error       //error returned by the last attempt, nil after a panic
+	Panic     interface{} //value of the recovered panic
+	Stack     []byte      //stack of the panic
+	Attempts  int
+}
+
+func (e *CallbackError) Error() string {
+	if e.Panic != nil {
+		return fmt.Sprintf("callback of event %s panicked : %v", e.EventName, e.Panic)
+	}
+	return fmt.Sprintf("callback of event %s failed after %d attempts : %v", e.EventName, e.Attempts, e.Err)
+}
+
+//ErrorHook is called with the failures of the callbacks of a channel
+type ErrorHook func(err *CallbackError)
+
+//printError is the error hook of the channels without one : the error is printed on stderr
+func printError(err *CallbackError) {
+	fmt.Fprintf(os.Stderr, "dispatcher : %s\n", err.Error())
+	if err.Stack != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Stack)
+	}
+}
+
+//Overflow is what happens when an event is published to a subscription whose queue is full
+type Overflow int
+
+//overflow policies
+const (
+	Block Overflow = iota
+	DropOldest
+	DropNewest
+)
+
+//DefaultRetryBackoff is the delay before the first retry of the subscriptions made without a RetryBackoff
+var DefaultRetryBackoff = 100 * time.Millisecond
+
+//DefaultBufferSize is the size of the queues of the subscriptions made without a BufferSize
+var DefaultBufferSize = 256
+
+//SubscribeOptions tunes a subscription. The zero value is a prefix subscription with a queue
+//of DefaultBufferSize events, blocking the publishers when full
+type SubscribeOptions struct {
+	Exact        bool //listen to the events named eventname only, instead of the ones starting with it
+	BufferSize   int
+	Overflow     Overflow
+	Retries      int           //retries of an ErrorCallback returning an error
+	RetryBackoff time.Duration //delay before the first retry, doubled for each of the next ones
+}
+
 //Channel is the place to register to or to broadcast events
 type Channel struct {
-	subscribers map[string][]Callback
+	dropped     uint64                     //first field, for the alignment of atomic operations
+	subscribers map[string][]*Subscription //subscriptions by event name prefix
+	exact       map[string][]*Subscription //subscriptions by exact event name
+	errorHook   ErrorHook
+	closed      bool
 	mutex       sync.Mutex
 }
 
-//Channels keep a list of created channels. Just for info
-var Channels map[string]*Channel
+//Subscription is returned by Subscribe and SubscribeExact, to stop listening with Unsubscribe
+type Subscription struct {
+	dropped   uint64 //first field, for the alignment of atomic operations
+	channel   *Channel
+	eventname string
+	options   SubscribeOptions
+	call      ErrorCallback
+	queue     chan event
+	mutex     sync.Mutex //serializes the non blocking writes to queue, and guards closed
+	closed    bool
+	closing   chan struct{}  //closed by Unsubscribe, waking up the blocked publishers
+	senders   sync.WaitGroup //publishers blocked on the queue, which is closed once they have left
+	finished  chan struct{}  //closed once the last event of the closed queue is delivered
+
+	pendingMutex sync.Mutex      //guards the fields below, apart from mutex which may be held while blocked
+	pending      int             //events queued or being delivered
+	idleWaiters  []chan struct{} //closed when pending is back to 0
+}
 
-//CreateChannel creates and init a channel
-func CreateChannel(name string) (*Channel, error) {
-	c := Channel{}
-	c.Init()
-	if len(name) > 0 {
-		if Channels == nil {
-			Channels = make(map[string]*Channel)
+//event is a published event waiting in the queue of a subscription
+type event struct {
+	name    string
+	payload interface{}
+	done    *sync.WaitGroup //for PublishSync, nil otherwise
+}
+
+//Init initialize the channel map
+func (c *Channel) Init() {
+	c.subscribers = make(map[string][]*Subscription)
+	c.exact = make(map[string][]*Subscription)
+}
+
+//Publish send an event to subscribers : the ones of a prefix of eventname, and the ones of eventname exactly.
+//Publish returns once the event is queued for every subscriber, or dropped according to their overflow policy
+func (c *Channel) Publish(eventname string, payload interface{}) {
+	e := event{name: eventname, payload: payload}
+	subs, _ := c.matching(eventname)
+	for _, s := range subs {
+		s.enqueue(context.Background(), e)
+	}
+}
+
+//PublishSync sends an event to subscribers and waits for their callbacks to have handled it,
+//or to have given up after their retries. Events dropped by an overflow policy count as handled.
+//The error of the context is returned when it is done first : the event may still be delivered
+func (c *Channel) PublishSync(ctx context.Context, eventname string, payload interface{}) error {
+	subs, err := c.matching(eventname)
+	if err != nil {
+		return err
+	}
+	var done sync.WaitGroup
+	e := event{name: eventname, payload: payload, done: &done}
+	done.Add(len(subs))
+	refused := false
+	for _, s := range subs {
+		refused = !s.enqueue(ctx, e) || refused
+	}
+	if err := wait(ctx, func() { done.Wait() }); err != nil {
+		return err
+	}
+	if refused {
+		//a subscription was cancelled meanwhile, by Close when the channel is closed now
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.closed {
+			return ErrClosed
 		}
-		if _, ok := Channels[name]; ok {
-			return nil, errors.New("Channel already exists")
+	}
+	return nil
+}
+
+//Drain waits for the events queued when it is called, and the ones published meanwhile, to be delivered
+func (c *Channel) Drain(ctx context.Context) error {
+	for _, s := range c.all() {
+		select {
+		case <-s.idle():
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		Channels[name] = &c
 	}
-	return &c, nil
+	return nil
 }
 
-//Init initialize the channel map
-func (c *Channel) Init() {
-	c.subscribers = make(map[string][]Callback)
+//Close stops accepting events and waits for the queued ones to be delivered.
+//The error of the context is returned when it is done first
+func (c *Channel) Close(ctx context.Context) error {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+	subs := c.all()
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	for _, s := range subs {
+		select {
+		case <-s.finished:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
 
+//wait runs f and waits for it to return, unless the context is done first
+func wait(ctx context.Context, f func()) error {
+	finished := make(chan struct{})
+	go func() {
+		f()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-//Publish send an event to subscribers
-func (c *Channel) Publish(eventname string, payload interface{}) {
+//all returns every subscription of the channel
+func (c *Channel) all() []*Subscription {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	for k, sub := range c.subscribers {
+	result := []*Subscription{}
+	for _, subscribers := range []map[string][]*Subscription{c.subscribers, c.exact} {
+		for _, subs := range subscribers {
+			result = append(result, subs...)
+		}
+	}
+	return result
+}
+
+//matching returns the subscriptions listening to an event, or ErrClosed once the channel is closed
+func (c *Channel) matching(eventname string) ([]*Subscription, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	result := []*Subscription{}
+	for k, subs := range c.subscribers {
 		if strings.HasPrefix(eventname, k) {
-			for _, c := range sub {
-				go c(eventname, payload)
-			}
+			result = append(result, subs...)
 		}
 	}
+	return append(result, c.exact[eventname]...), nil
 }
 
-//Subscribe : listen to events
-func (c *Channel) Subscribe(eventname string, call Callback) {
+//SetErrorHook sets the function called with the failures of the callbacks. nil prints them on stderr
+func (c *Channel) SetErrorHook(hook ErrorHook) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	subs, ok := c.subscribers[eventname]
-	if !ok {
-		c.subscribers[eventname] = []Callback{call}
+	c.errorHook = hook
+}
+
+//reportError calls the error hook of the channel
+func (c *Channel) reportError(err *CallbackError) {
+	c.mutex.Lock()
+	hook := c.errorHook
+	c.mutex.Unlock()
+	if hook == nil {
+		hook = printError
+	}
+	hook(err)
+}
+
+//Dropped returns the number of events dropped by the subscriptions of the channel, since its creation
+func (c *Channel) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+//Subscribe : listen to the events whose name starts with eventname. An empty eventname listens to every event
+func (c *Channel) Subscribe(eventname string, call Callback) *Subscription {
+	return c.SubscribeWith(eventname, call, SubscribeOptions{})
+}
+
+//SubscribeExact : listen to the events named eventname only
+func (c *Channel) SubscribeExact(eventname string, call Callback) *Subscription {
+	return c.SubscribeWith(eventname, call, SubscribeOptions{Exact: true})
+}
+
+//SubscribeWith : listen to events with specific options
+func (c *Channel) SubscribeWith(eventname string, call Callback, options SubscribeOptions) *Subscription {
+	options.Retries = 0
+	return c.SubscribeErr(eventname, func(eventname string, p interface{}) error {
+		call(eventname, p)
+		return nil
+	}, options)
+}
+
+//SubscribeErr : listen to events with a callback whose errors are retried according to the options.
+//Subscribing to a closed channel returns an already unsubscribed subscription
+func (c *Channel) SubscribeErr(eventname string, call ErrorCallback, options SubscribeOptions) *Subscription {
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultBufferSize
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = DefaultRetryBackoff
+	}
+	s := &Subscription{channel: c, eventname: eventname, options: options, call: call,
+		queue: make(chan event, options.BufferSize), closing: make(chan struct{}), finished: make(chan struct{})}
+	go s.deliver()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		s.closed = true
+		close(s.closing)
+		close(s.queue)
+		return s
+	}
+	subscribers := c.subscribers
+	if options.Exact {
+		subscribers = c.exact
+	}
+	subscribers[eventname] = append(subscribers[eventname], s)
+	return s
+}
+
+//enqueue adds an event to the queue, applying the overflow policy when it is full.
+//A blocked publisher gives up when the context is done or the subscription is cancelled, the event being dropped.
+//false is returned when the subscription was already cancelled
+func (s *Subscription) enqueue(ctx context.Context, e event) bool {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		s.settle(e, false)
+		return false
+	}
+	s.pendingMutex.Lock()
+	s.pending++
+	s.pendingMutex.Unlock()
+	if s.options.Overflow == Block {
+		//the mutex is not held while blocked : the callback itself may need it to unsubscribe
+		s.senders.Add(1)
+		s.mutex.Unlock()
+		defer s.senders.Done()
+		select {
+		case s.queue <- e:
+			return true
+		case <-ctx.Done():
+		case <-s.closing:
+		}
+		s.drop(e)
+		return true
+	}
+	defer s.mutex.Unlock()
+	switch s.options.Overflow {
+	case DropOldest:
+		select {
+		case s.queue <- e:
+			return true
+		default:
+		}
+		//every publisher of a drop policy holds the mutex : once an event is removed there is room for the new one
+		select {
+		case oldest := <-s.queue:
+			s.queue <- e
+			e = oldest
+		default:
+			s.queue <- e
+			return true
+		}
+	case DropNewest:
+		select {
+		case s.queue <- e:
+			return true
+		default:
+		}
+	}
+	s.drop(e)
+	return true
+}
+
+//drop counts an event which will not be delivered
+func (s *Subscription) drop(e event) {
+	atomic.AddUint64(&s.dropped, 1)
+	atomic.AddUint64(&s.channel.dropped, 1)
+	s.settle(e, true)
+}
+
+//settle records that an event is delivered or dropped. pending tells if it was counted in s.pending
+func (s *Subscription) settle(e event, pending bool) {
+	if e.done != nil {
+		e.done.Done()
+	}
+	if !pending {
+		return
+	}
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+	if s.pending--; s.pending == 0 {
+		for _, w := range s.idleWaiters {
+			close(w)
+		}
+		s.idleWaiters = nil
+	}
+}
+
+//idle returns a channel closed once no event is queued nor being delivered
+func (s *Subscription) idle() <-chan struct{} {
+	s.pendingMutex.Lock()
+	defer s.pendingMutex.Unlock()
+	w := make(chan struct{})
+	if s.pending == 0 {
+		close(w)
+	} else {
+		s.idleWaiters = append(s.idleWaiters, w)
+	}
+	return w
+}
+
+//deliver calls the callback for every queued event, until the queue is closed and empty
+func (s *Subscription) deliver() {
+	defer close(s.finished)
+	for e := range s.queue {
+		backoff := s.options.RetryBackoff
+		for attempt := 1; ; attempt++ {
+			err := s.attempt(e)
+			if err == nil {
+				break
+			}
+			if err.Panic != nil || attempt > s.options.Retries {
+				err.Attempts = attempt
+				s.channel.reportError(err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		s.settle(e, true)
+	}
+}
+
+//attempt calls the callback once, recovering its panics
+func (s *Subscription) attempt(e event) (result *CallbackError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = &CallbackError{EventName: e.name, Payload: e.payload, Panic: r, Stack: debug.Stack()}
+		}
+	}()
+	if err := s.call(e.name, e.payload); err != nil {
+		return &CallbackError{EventName: e.name, Payload: e.payload, Err: err}
+	}
+	return nil
+}
+
+//Dropped returns the number of events dropped by the subscription
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+//Queued returns the number of events waiting to be delivered
+func (s *Subscription) Queued() int {
+	return len(s.queue)
+}
+
+//Unsubscribe stops listening to events. The events already queued are still delivered.
+//Unsubscribing twice does nothing
+func (s *Subscription) Unsubscribe() {
+	c := s.channel
+	c.mutex.Lock()
+	subscribers := c.subscribers
+	if s.options.Exact {
+		subscribers = c.exact
+	}
+	subs := subscribers[s.eventname]
+	for i, sub := range subs {
+		if sub == s {
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(subscribers, s.eventname)
+	} else {
+		subscribers[s.eventname] = subs
+	}
+	c.mutex.Unlock()
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
 		return
 	}
-	c.subscribers[eventname] = append(subs, call)
+	s.closed = true
+	close(s.closing)
+	s.mutex.Unlock()
+	//no publisher may start sending once closed is set : the queue can be closed when the blocked ones have left
+	s.senders.Wait()
+	close(s.queue)
 }
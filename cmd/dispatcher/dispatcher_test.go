@@ -0,0 +1,232 @@
+package dispatcher
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testTimeout bounds every wait of the tests, a deadlock failing instead of hanging
+const testTimeout = 5 * time.Second
+
+//recorder is a callback recording the payloads it receives. Once gated, the callback
+//blocks on every event until release is called, signalling on started when it begins
+type recorder struct {
+	mutex    sync.Mutex
+	payloads []interface{}
+	started  chan interface{}
+	gate     chan struct{}
+}
+
+func newRecorder(gated bool) *recorder {
+	r := &recorder{started: make(chan interface{}, 100)}
+	if gated {
+		r.gate = make(chan struct{})
+	}
+	return r
+}
+
+func (r *recorder) call(eventname string, p interface{}) {
+	select {
+	case r.started <- p:
+	default:
+	}
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.payloads = append(r.payloads, p)
+}
+
+func (r *recorder) release() {
+	close(r.gate)
+}
+
+func (r *recorder) got() []interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]interface{}{}, r.payloads...)
+}
+
+//waitStarted waits for the callback to start handling an event
+func (r *recorder) waitStarted(t *testing.T) interface{} {
+	t.Helper()
+	select {
+	case p := <-r.started:
+		return p
+	case <-time.After(testTimeout):
+		t.Fatal("callback not called")
+	}
+	return nil
+}
+
+//waitCount waits for the callback to have handled n events
+func (r *recorder) waitCount(t *testing.T, n int) []interface{} {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		if got := r.got(); len(got) >= n {
+			return got
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("callback handled %d events, want %d", len(r.got()), n)
+	return nil
+}
+
+func newTestChannel(t *testing.T) *Channel {
+	t.Helper()
+	c, err := CreateChannel("")
+	if err != nil {
+		t.Fatalf("CreateChannel : %v", err)
+	}
+	return c
+}
+
+//waitDone fails the test when done is not closed in time
+func waitDone(t *testing.T, done chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatalf("%s : deadlock", what)
+	}
+}
+
+func TestSubscribeMatching(t *testing.T) {
+	c := newTestChannel(t)
+	prefix, exact, all := newRecorder(false), newRecorder(false), newRecorder(false)
+	c.Subscribe("right.", prefix.call)
+	c.SubscribeExact("right.granted", exact.call)
+	c.Subscribe("", all.call)
+	c.Publish("right.granted", 1)
+	c.Publish("right.granted.more", 2)
+	c.Publish("role.deleted", 3)
+	if got := all.waitCount(t, 3); !reflect.DeepEqual(got, []interface{}{1, 2, 3}) {
+		t.Errorf("catch all subscription got %v", got)
+	}
+	if got := prefix.waitCount(t, 2); !reflect.DeepEqual(got, []interface{}{1, 2}) {
+		t.Errorf("prefix subscription got %v", got)
+	}
+	if got := exact.waitCount(t, 1); !reflect.DeepEqual(got, []interface{}{1}) {
+		t.Errorf("exact subscription got %v", got)
+	}
+}
+
+func TestDeliveryOrder(t *testing.T) {
+	c := newTestChannel(t)
+	r := newRecorder(false)
+	c.SubscribeWith("", r.call, SubscribeOptions{BufferSize: 4})
+	want := []interface{}{}
+	for i := 0; i < 1000; i++ {
+		c.Publish("e", i)
+		want = append(want, i)
+	}
+	if got := r.waitCount(t, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("events delivered out of order : %v", got)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	tests := []struct {
+		overflow Overflow
+		want     []interface{}
+		dropped  uint64
+	}{
+		{Block, []interface{}{0, 1, 2, 3, 4, 5}, 0},
+		{DropOldest, []interface{}{0, 4, 5}, 3},
+		{DropNewest, []interface{}{0, 1, 2}, 3},
+	}
+	for _, test := range tests {
+		c := newTestChannel(t)
+		r := newRecorder(true)
+		s := c.SubscribeWith("", r.call, SubscribeOptions{BufferSize: 2, Overflow: test.overflow})
+		//the callback holds event 0 : the next events fill the queue of 2
+		c.Publish("e", 0)
+		r.waitStarted(t)
+		published := make(chan struct{})
+		go func() {
+			for i := 1; i <= 5; i++ {
+				c.Publish("e", i)
+			}
+			close(published)
+		}()
+		if test.overflow != Block {
+			waitDone(t, published, "publishing with a drop policy")
+		} else {
+			select {
+			case <-published:
+				t.Errorf("Block : publisher not blocked by a full queue")
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		r.release()
+		waitDone(t, published, "publishing")
+		if got := r.waitCount(t, len(test.want)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("overflow %d : delivered %v, want %v", test.overflow, got, test.want)
+		}
+		if s.Dropped() != test.dropped || c.Dropped() != test.dropped {
+			t.Errorf("overflow %d : dropped %d on the subscription and %d on the channel, want %d",
+				test.overflow, s.Dropped(), c.Dropped(), test.dropped)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	c := newTestChannel(t)
+	r, exact := newRecorder(false), newRecorder(false)
+	s := c.Subscribe("e", r.call)
+	e := c.SubscribeExact("e", exact.call)
+	c.Publish("e", 1)
+	r.waitCount(t, 1)
+	exact.waitCount(t, 1)
+	s.Unsubscribe()
+	s.Unsubscribe()
+	e.Unsubscribe()
+	c.Publish("e", 2)
+	other := newRecorder(false)
+	c.Subscribe("e", other.call)
+	c.Publish("e", 3)
+	other.waitCount(t, 1)
+	if got := r.got(); !reflect.DeepEqual(got, []interface{}{1}) {
+		t.Errorf("unsubscribed callback got %v", got)
+	}
+	if got := exact.got(); !reflect.DeepEqual(got, []interface{}{1}) {
+		t.Errorf("unsubscribed exact callback got %v", got)
+	}
+	if len(c.subscribers["e"]) != 1 || len(c.exact) != 0 {
+		t.Errorf("channel keeps %d prefix and %d exact subscriptions, want 1 and 0", len(c.subscribers["e"]), len(c.exact))
+	}
+}
+
+func TestSelfUnsubscribeWithBlockedPublisher(t *testing.T) {
+	c := newTestChannel(t)
+	r := newRecorder(true)
+	var s *Subscription
+	unsubscribed := make(chan struct{})
+	s = c.SubscribeWith("", func(eventname string, p interface{}) {
+		r.call(eventname, p)
+		if p == 0 {
+			s.Unsubscribe()
+			close(unsubscribed)
+		}
+	}, SubscribeOptions{BufferSize: 1})
+	c.Publish("e", 0)
+	r.waitStarted(t)
+	c.Publish("e", 1)
+	published := make(chan struct{})
+	go func() {
+		//blocked by the full queue until the subscription is cancelled
+		c.Publish("e", 2)
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.release()
+	waitDone(t, unsubscribed, "unsubscribing from the callback")
+	waitDone(t, published, "publishing to a cancelled subscription")
+	if got := r.waitCount(t, 2); !reflect.DeepEqual(got, []interface{}{0, 1}) {
+		t.Errorf("delivered %v, want the events queued before unsubscribing", got)
+	}
+}
@@ -0,0 +1,123 @@
+package dispatcher
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+//hookRecorder is an error hook recording the failures it receives
+type hookRecorder struct {
+	errs chan *CallbackError
+}
+
+func newHookRecorder(c *Channel) *hookRecorder {
+	h := &hookRecorder{errs: make(chan *CallbackError, 10)}
+	c.SetErrorHook(func(err *CallbackError) { h.errs <- err })
+	return h
+}
+
+func (h *hookRecorder) wait(t *testing.T) *CallbackError {
+	t.Helper()
+	select {
+	case err := <-h.errs:
+		return err
+	case <-time.After(testTimeout):
+		t.Fatal("error hook not called")
+	}
+	return nil
+}
+
+//none fails the test when the hook was called
+func (h *hookRecorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case err := <-h.errs:
+		t.Errorf("error hook unexpectedly called with %v", err)
+	default:
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	c := newTestChannel(t)
+	hook := newHookRecorder(c)
+	failure := errors.New("unavailable")
+	var mutex sync.Mutex
+	attempts := []time.Time{}
+	backoff := 10 * time.Millisecond
+	c.SubscribeErr("e", func(eventname string, p interface{}) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts = append(attempts, time.Now())
+		return failure
+	}, SubscribeOptions{Retries: 3, RetryBackoff: backoff})
+	c.Publish("e", 1)
+
+	err := hook.wait(t)
+	if err.Err != failure || err.Attempts != 4 || err.EventName != "e" || err.Payload != 1 || err.Panic != nil {
+		t.Errorf("hook got %+v, want the error of the 4th attempt at e(1)", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(attempts) != 4 {
+		t.Fatalf("callback called %d times, want 1 attempt and 3 retries", len(attempts))
+	}
+	//the backoff doubles after each retry
+	for i := 1; i < len(attempts); i++ {
+		if gap := attempts[i].Sub(attempts[i-1]); gap < backoff {
+			t.Errorf("retry %d after %v, want at least %v", i, gap, backoff)
+		}
+		backoff *= 2
+	}
+}
+
+func TestRetrySuccess(t *testing.T) {
+	c := newTestChannel(t)
+	hook := newHookRecorder(c)
+	r := newRecorder(false)
+	calls := 0
+	c.SubscribeErr("e", func(eventname string, p interface{}) error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		r.call(eventname, p)
+		return nil
+	}, SubscribeOptions{Retries: 5, RetryBackoff: time.Millisecond})
+	c.Publish("e", 1)
+	c.Publish("e", 2)
+	//the next event waits for the retries of the previous one
+	if got := r.waitCount(t, 2); got[0] != 1 || got[1] != 2 {
+		t.Errorf("delivered %v, want [1 2]", got)
+	}
+	if calls != 4 {
+		t.Errorf("callback called %d times, want 4", calls)
+	}
+	hook.none(t)
+}
+
+func TestPanicRecovered(t *testing.T) {
+	c := newTestChannel(t)
+	hook := newHookRecorder(c)
+	r := newRecorder(false)
+	c.SubscribeErr("e", func(eventname string, p interface{}) error {
+		if p == 1 {
+			panic("boom")
+		}
+		r.call(eventname, p)
+		return nil
+	}, SubscribeOptions{Retries: 3, RetryBackoff: time.Millisecond})
+	c.Publish("e", 1)
+	c.Publish("e", 2)
+
+	//a panic is reported at once, without retries, and the delivery goes on
+	err := hook.wait(t)
+	if err.Panic != "boom" || err.Attempts != 1 || err.Err != nil || err.Payload != 1 || len(err.Stack) == 0 {
+		t.Errorf("hook got %+v, want the recovered panic of e(1) with its stack", err)
+	}
+	if got := r.waitCount(t, 1); got[0] != 2 {
+		t.Errorf("delivered %v after the panic, want [2]", got)
+	}
+	hook.none(t)
+}
@@ -0,0 +1,127 @@
+package dispatcher
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+//ErrChannelExists matches, with errors.Is, the errors returned when creating a channel under a name already registered
+var ErrChannelExists = errors.New("Channel already exists")
+
+//ChannelExistsError is returned when creating a channel under a name already registered
+type ChannelExistsError struct {
+	Name string
+}
+
+func (e *ChannelExistsError) Error() string {
+	return "Channel " + e.Name + " already exists"
+}
+
+//Is makes errors.Is(err, ErrChannelExists) true
+func (e *ChannelExistsError) Is(target error) bool {
+	return target == ErrChannelExists
+}
+
+//Registry keeps channels by name. It is safe for concurrent use
+type Registry struct {
+	mutex    sync.RWMutex
+	channels map[string]*Channel
+}
+
+//DefaultRegistry holds the channels created by the package level functions
+var DefaultRegistry = NewRegistry()
+
+//NewRegistry creates an empty registry, independent from DefaultRegistry
+func NewRegistry() *Registry {
+	return &Registry{channels: make(map[string]*Channel)}
+}
+
+//newChannel creates and init a channel
+func newChannel() *Channel {
+	c := &Channel{}
+	c.Init()
+	return c
+}
+
+//Create creates a channel and registers it under name. An empty name creates an unregistered channel.
+//A *ChannelExistsError is returned when the name is already registered
+func (r *Registry) Create(name string) (*Channel, error) {
+	if name == "" {
+		return newChannel(), nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.channels[name]; ok {
+		return nil, &ChannelExistsError{Name: name}
+	}
+	c := newChannel()
+	r.channels[name] = c
+	return c, nil
+}
+
+//Get returns the channel registered under name
+func (r *Registry) Get(name string) (*Channel, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	c, ok := r.channels[name]
+	return c, ok
+}
+
+//GetOrCreate returns the channel registered under name, creating it if needed
+func (r *Registry) GetOrCreate(name string) *Channel {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.channels[name]
+	if !ok {
+		c = newChannel()
+		r.channels[name] = c
+	}
+	return c
+}
+
+//Remove unregisters a channel and returns it. The channel is not closed
+func (r *Registry) Remove(name string) (*Channel, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.channels[name]
+	delete(r.channels, name)
+	return c, ok
+}
+
+//List returns the names of the registered channels, sorted
+func (r *Registry) List() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	result := make([]string, 0, len(r.channels))
+	for name := range r.channels {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+//CreateChannel creates and init a channel, registered in DefaultRegistry unless name is empty
+func CreateChannel(name string) (*Channel, error) {
+	return DefaultRegistry.Create(name)
+}
+
+//Get returns the channel of DefaultRegistry registered under name
+func Get(name string) (*Channel, bool) {
+	return DefaultRegistry.Get(name)
+}
+
+//GetOrCreate returns the channel of DefaultRegistry registered under name, creating it if needed
+func GetOrCreate(name string) *Channel {
+	return DefaultRegistry.GetOrCreate(name)
+}
+
+//Remove unregisters a channel of DefaultRegistry and returns it
+func Remove(name string) (*Channel, bool) {
+	return DefaultRegistry.Remove(name)
+}
+
+//List returns the names of the channels of DefaultRegistry, sorted
+func List() []string {
+	return DefaultRegistry.List()
+}
@@ -0,0 +1,96 @@
+package dispatcher
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRegistryCreate(t *testing.T) {
+	r := NewRegistry()
+	c, err := r.Create("rights")
+	if err != nil {
+		t.Fatalf("Create : %v", err)
+	}
+	if got, ok := r.Get("rights"); !ok || got != c {
+		t.Errorf("Get returned %v, %v, want the created channel", got, ok)
+	}
+	_, err = r.Create("rights")
+	if !errors.Is(err, ErrChannelExists) {
+		t.Errorf("duplicate Create returned %v, want ErrChannelExists", err)
+	}
+	var exists *ChannelExistsError
+	if !errors.As(err, &exists) || exists.Name != "rights" {
+		t.Errorf("duplicate Create returned %v, want a ChannelExistsError naming rights", err)
+	}
+	//unnamed channels are not registered and never collide
+	a, errA := r.Create("")
+	b, errB := r.Create("")
+	if errA != nil || errB != nil || a == b {
+		t.Errorf("unnamed Create returned %v, %v", errA, errB)
+	}
+	if got := r.List(); !reflect.DeepEqual(got, []string{"rights"}) {
+		t.Errorf("List returned %v", got)
+	}
+}
+
+func TestRegistryGetOrCreate(t *testing.T) {
+	r := NewRegistry()
+	if _, ok := r.Get("users"); ok {
+		t.Fatal("Get found a channel in an empty registry")
+	}
+	c := r.GetOrCreate("users")
+	if c == nil || r.GetOrCreate("users") != c {
+		t.Error("GetOrCreate did not return the channel it created")
+	}
+	if _, err := r.Create("users"); !errors.Is(err, ErrChannelExists) {
+		t.Errorf("Create after GetOrCreate returned %v, want ErrChannelExists", err)
+	}
+	if removed, ok := r.Remove("users"); !ok || removed != c {
+		t.Errorf("Remove returned %v, %v, want the channel", removed, ok)
+	}
+	if _, ok := r.Remove("users"); ok {
+		t.Error("Remove found a removed channel")
+	}
+	if r.GetOrCreate("users") == c {
+		t.Error("GetOrCreate returned the removed channel")
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	const workers = 20
+	var wg sync.WaitGroup
+	channels := make([]*Channel, workers)
+	created := make([]error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			channels[i] = r.GetOrCreate("shared")
+			_, created[i] = r.Create("unique")
+			r.GetOrCreate("worker" + strconv.Itoa(i))
+			r.List()
+		}(i)
+	}
+	wg.Wait()
+	succeeded := 0
+	for i := 0; i < workers; i++ {
+		if channels[i] != channels[0] {
+			t.Errorf("worker %d got another shared channel", i)
+		}
+		if created[i] == nil {
+			succeeded++
+		} else if !errors.Is(created[i], ErrChannelExists) {
+			t.Errorf("worker %d : Create returned %v", i, created[i])
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d workers created the unique channel, want 1", succeeded)
+	}
+	if got := len(r.List()); got != workers+2 {
+		t.Errorf("registry holds %d channels, want %d", got, workers+2)
+	}
+}
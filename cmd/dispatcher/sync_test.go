@@ -0,0 +1,177 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublishSync(t *testing.T) {
+	c := newTestChannel(t)
+	var handled int32
+	slow := func(string, interface{}) {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+	}
+	c.Subscribe("e", slow)
+	c.SubscribeExact("e.sync", slow)
+	c.SubscribeWith("e", func(string, interface{}) { panic("boom") }, SubscribeOptions{})
+	c.SetErrorHook(func(*CallbackError) {})
+	if err := c.PublishSync(context.Background(), "e.sync", nil); err != nil {
+		t.Fatalf("PublishSync : %v", err)
+	}
+	if n := atomic.LoadInt32(&handled); n != 2 {
+		t.Errorf("PublishSync returned after %d callbacks, want 2", n)
+	}
+}
+
+func TestPublishSyncDeadline(t *testing.T) {
+	c := newTestChannel(t)
+	r := newRecorder(true)
+	c.Subscribe("e", r.call)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.PublishSync(ctx, "e", 1); err != context.DeadlineExceeded {
+		t.Errorf("PublishSync to a stuck subscriber = %v, want %v", err, context.DeadlineExceeded)
+	}
+	//the event is still delivered once the subscriber is back
+	r.release()
+	r.waitCount(t, 1)
+}
+
+func TestPublishSyncDeadlineWhileBlocked(t *testing.T) {
+	c := newTestChannel(t)
+	r := newRecorder(true)
+	s := c.SubscribeWith("e", r.call, SubscribeOptions{BufferSize: 1})
+	c.Publish("e", 0)
+	r.waitStarted(t)
+	c.Publish("e", 1)
+	//the queue is full : the publisher gives up at the deadline and the event is dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.PublishSync(ctx, "e", 2); err != context.DeadlineExceeded {
+		t.Errorf("PublishSync to a full queue = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if s.Dropped() != 1 {
+		t.Errorf("dropped %d events, want 1", s.Dropped())
+	}
+	r.release()
+}
+
+func TestPublishSyncClosed(t *testing.T) {
+	c := newTestChannel(t)
+	c.Subscribe("e", func(string, interface{}) {})
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("Close : %v", err)
+	}
+	if err := c.PublishSync(context.Background(), "e", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("PublishSync on a closed channel = %v, want %v", err, ErrClosed)
+	}
+	//Publish does nothing and new subscriptions get nothing
+	r := newRecorder(false)
+	c.Subscribe("e", r.call)
+	c.Publish("e", nil)
+	time.Sleep(10 * time.Millisecond)
+	if got := r.got(); len(got) != 0 {
+		t.Errorf("subscription to a closed channel got %v", got)
+	}
+}
+
+func TestPublishSyncRacingClose(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		c := newTestChannel(t)
+		var handled int32
+		c.Subscribe("e", func(string, interface{}) { atomic.AddInt32(&handled, 1) })
+		result := make(chan error)
+		go func() { result <- c.PublishSync(context.Background(), "e", nil) }()
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatalf("Close : %v", err)
+		}
+		//either the event was accepted and handled before Close returned, or it was refused
+		err := <-result
+		if err == nil && atomic.LoadInt32(&handled) != 1 {
+			t.Fatalf("PublishSync returned nil without handling the event")
+		}
+		if err != nil && err != ErrClosed {
+			t.Fatalf("PublishSync = %v, want nil or %v", err, ErrClosed)
+		}
+	}
+}
+
+func TestDrainWhilePublishing(t *testing.T) {
+	c := newTestChannel(t)
+	var handled int32
+	c.SubscribeWith("e", func(string, interface{}) {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+	}, SubscribeOptions{BufferSize: 8})
+	for i := 0; i < 20; i++ {
+		c.Publish("e", i)
+	}
+	stop := make(chan struct{})
+	publishing := make(chan struct{})
+	go func() {
+		defer close(publishing)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Publish("e", nil)
+			}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	//the publisher never lets the queue empty
+	if err := c.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain while publishing = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(stop)
+	<-publishing
+	if err := c.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain : %v", err)
+	}
+	for _, s := range c.all() {
+		if s.Queued() != 0 {
+			t.Errorf("%d events still queued after Drain", s.Queued())
+		}
+	}
+	if n := atomic.LoadInt32(&handled); n < 20 {
+		t.Errorf("Drain returned after %d events, want at least 20", n)
+	}
+}
+
+func TestCloseWaitsForQueuedEvents(t *testing.T) {
+	c := newTestChannel(t)
+	var handled int32
+	c.Subscribe("e", func(string, interface{}) {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+	})
+	for i := 0; i < 50; i++ {
+		c.Publish("e", i)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("Close : %v", err)
+	}
+	if n := atomic.LoadInt32(&handled); n != 50 {
+		t.Errorf("Close returned after %d events, want 50", n)
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	c := newTestChannel(t)
+	r := newRecorder(true)
+	c.Subscribe("e", r.call)
+	c.Publish("e", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Close with a stuck subscriber = %v, want %v", err, context.DeadlineExceeded)
+	}
+	r.release()
+	r.waitCount(t, 1)
+}
@@ -0,0 +1,45 @@
+package mail
+
+/**** mail holds the way mauth sends emails to users
+
+mauth does not talk to a mail server itself : it hands messages to a Sender.
+LogSender is a stand-in for local runs, it writes messages to a file or to the standard output.
+
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//Sender sends a plain text email
+type Sender interface {
+	Send(to string, subject string, body string) error
+}
+
+//LogSender writes emails to the file at Path, or to the standard output when Path is empty
+type LogSender struct {
+	Path string
+}
+
+var logMutex sync.Mutex
+
+//Send appends the email to the log
+func (s LogSender) Send(to string, subject string, body string) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	var out io.Writer = os.Stdout
+	if s.Path != "" {
+		f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err := fmt.Fprintf(out, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().UTC().Format(time.RFC3339), to, subject, body)
+	return err
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authn"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//adminTokenHeader is the header holding the token giving access to the /admin routes
+const adminTokenHeader = "X-Admin-Token"
+
+//requireAdmin is a middleware rejecting requests without the ADMIN_TOKEN of the environment.
+//Admin routes are disabled when ADMIN_TOKEN is not set
+func requireAdmin(next http.Handler) http.Handler {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(adminTokenHeader)
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			sendHTTP(w, 403, map[string]interface{}{"status": "error", "error": "forbidden"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//adminRoutes registers the /admin routes
+func adminRoutes(router chi.Router) {
+	router.Use(requireAdmin)
+	router.Post("/domain", adminCreateDomain)
+	router.Post("/domain/{domainID}/token", adminRegenerateDomainToken)
+	router.Post("/domain/{domainID}/settings", adminDomainSettings)
+	router.Post("/domain/{domainID}/action", adminCreateAction)
+	router.Get("/domain/{domainID}/action", adminListActions)
+	router.Post("/domain/{domainID}/role", adminCreateRole)
+	router.Get("/domain/{domainID}/role", adminListRoles)
+	router.Get("/domain/{domainID}/role/{roleID}/action", adminRoleActions)
+	router.Post("/domain/{domainID}/role/{roleID}/action", adminRoleAddAction)
+	router.Post("/domain/{domainID}/role/{roleID}/action/{actionID}/remove", adminRoleRemoveAction)
+	router.Post("/domain/{domainID}/role/{roleID}/mfa", adminRoleMFA)
+	router.Post("/domain/{domainID}/role/{roleID}/include", adminRoleInclude)
+	router.Post("/domain/{domainID}/role/{roleID}/exclude", adminRoleExclude)
+	router.Route("/domain/{domainID}/user", userAdminRoutes)
+	router.Route("/domain/{domainID}/group", groupRoutes)
+	if authnEnabled {
+		router.Post("/domain/{domainID}/rotatekey", adminRotateDomainKey)
+		router.Post("/domain/{domainID}/unlock", adminUnlockLogin)
+		router.Post("/unlock", adminUnlockIP)
+	}
+}
+
+//domainFromURL reads the domain of a route like /domain/{domainID}
+func domainFromURL(w http.ResponseWriter, r *http.Request) *authz.Domain {
+	id, err := strconv.ParseUint(chi.URLParam(r, "domainID"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return nil
+	}
+	d, err := authz.GetDomainByID(id)
+	if err != nil {
+		sendAuthzError(w, err)
+		return nil
+	}
+	return d
+}
+
+//roleFromID reads a role of the domain from its ID. nil is returned once an error has been sent
+func roleFromID(w http.ResponseWriter, d *authz.Domain, value string) *authz.Role {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return nil
+	}
+	role, err := authz.GetRoleByID(id)
+	if err == nil && role.DomainID != d.ID {
+		err = authz.ErrNotFound
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return nil
+	}
+	return role
+}
+
+//adminCreateDomain answers POST /admin/domain : registers a domain and returns its token.
+//In file mode the domain gets no signing key, its token only authenticating checks
+func adminCreateDomain(w http.ResponseWriter, r *http.Request) {
+	if !authnEnabled {
+		d, err := authz.CreateDomain(r.PostFormValue("name"))
+		if err != nil {
+			sendAuthzError(w, err)
+			return
+		}
+		adminSendDomainToken(w, d)
+		return
+	}
+	d, token, err := authn.RegisterDomain(r.PostFormValue("name"))
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "domain": d, "domain_token": token})
+}
+
+//adminRegenerateDomainToken answers POST /admin/domain/{domainID}/token
+func adminRegenerateDomainToken(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	adminSendDomainToken(w, d)
+}
+
+//adminSendDomainToken gives a new token to the domain and sends it
+func adminSendDomainToken(w http.ResponseWriter, d *authz.Domain) {
+	token, err := authn.RegenerateDomainToken(d)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "domain": d, "domain_token": token})
+}
+
+//adminRotateDomainKey answers POST /admin/domain/{domainID}/rotatekey.
+//The optional grace post value is the number of seconds previous keys keep verifying tokens
+func adminRotateDomainKey(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	grace := time.Duration(-1)
+	if v := r.PostFormValue("grace"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "grace must be a positive number of seconds"})
+			return
+		}
+		grace = time.Duration(seconds) * time.Second
+	}
+	key, err := authn.RotateDomainKey(d.ID, grace)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "key": key})
+}
+
+//adminDomainSettings answers POST /admin/domain/{domainID}/settings : updates the registration
+//settings and the password policy of the domain. Missing post values are left unchanged
+func adminDomainSettings(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	flags := map[string]*bool{
+		"allow_registration":          &d.AllowRegistration,
+		"password_require_mixed_case": &d.PasswordRequireMixedCase,
+		"password_require_digit":      &d.PasswordRequireDigit,
+		"password_require_symbol":     &d.PasswordRequireSymbol,
+	}
+	for name, flag := range flags {
+		v := r.PostFormValue(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": name + " must be a boolean"})
+			return
+		}
+		*flag = b
+	}
+	if v := r.PostFormValue("password_min_length"); v != "" {
+		length, err := strconv.Atoi(v)
+		if err != nil || length < 1 {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "password_min_length must be a positive integer"})
+			return
+		}
+		d.PasswordMinLength = length
+	}
+	if err := d.Save(); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "domain": d})
+}
+
+//adminRoleMFA answers POST /admin/domain/{domainID}/role/{roleID}/mfa : the require post value tells
+//whether the users holding the role must authenticate with a second factor
+func adminRoleMFA(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role := roleFromID(w, d, chi.URLParam(r, "roleID"))
+	if role == nil {
+		return
+	}
+	require, err := strconv.ParseBool(r.PostFormValue("require"))
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "require must be a boolean"})
+		return
+	}
+	if err := role.SetRequireMFA(require); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "role": role})
+}
+
+//adminUnlockLogin answers POST /admin/domain/{domainID}/unlock : lifts the lock and the backoff of a login
+func adminUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	unlocked := authn.Unlock(d.ID, r.PostFormValue("login"))
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "unlocked": unlocked})
+}
+
+//adminUnlockIP answers POST /admin/unlock : lifts the lock of the ip post value
+func adminUnlockIP(w http.ResponseWriter, r *http.Request) {
+	unlocked := authn.UnlockIP(r.PostFormValue("ip"))
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "unlocked": unlocked})
+}
+
+//adminRoleInclude answers POST /admin/domain/{domainID}/role/{roleID}/include : the role gets every
+//action of the role_id post value. Includes creating a cycle are refused
+func adminRoleInclude(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role := roleFromID(w, d, chi.URLParam(r, "roleID"))
+	if role == nil {
+		return
+	}
+	included := roleFromID(w, d, r.PostFormValue("role_id"))
+	if included == nil {
+		return
+	}
+	if err := role.IncludeRole(included); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//adminRoleExclude answers POST /admin/domain/{domainID}/role/{roleID}/exclude : removes the include of
+//the role_id post value
+func adminRoleExclude(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role := roleFromID(w, d, chi.URLParam(r, "roleID"))
+	if role == nil {
+		return
+	}
+	included := roleFromID(w, d, r.PostFormValue("role_id"))
+	if included == nil {
+		return
+	}
+	if err := role.ExcludeRole(included); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authn"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//apiKeyRoutes registers the /apikey routes, used by a logged user to manage their keys
+func apiKeyRoutes(router chi.Router) {
+	router.Use(requireSession)
+	router.Post("/", apiKeyCreate)
+	router.Get("/", apiKeyList)
+	router.Post("/{keyID}/revoke", apiKeyRevoke)
+}
+
+//apiKeyCreate answers POST /apikey. Post values : name, expires_at (RFC3339, optional),
+//role_ids (comma separated, optional)
+func apiKeyCreate(w http.ResponseWriter, r *http.Request) {
+	u, err := authz.GetUserByID(sessionClaims(r).UserID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	var expiresAt *time.Time
+	if v := r.PostFormValue("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "expires_at must be a RFC3339 date"})
+			return
+		}
+		expiresAt = &t
+	}
+	var roleIDs []uint64
+	if v := r.PostFormValue("role_ids"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "role_ids must be a list of integers"})
+				return
+			}
+			roleIDs = append(roleIDs, id)
+		}
+	}
+	k, key, err := authn.CreateAPIKey(u, r.PostFormValue("name"), expiresAt, roleIDs)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "apikey": k, "key": key})
+}
+
+//apiKeyList answers GET /apikey with the keys of the logged user
+func apiKeyList(w http.ResponseWriter, r *http.Request) {
+	keys, err := authn.GetUserAPIKeys(sessionClaims(r).UserID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "apikeys": keys})
+}
+
+//apiKeyRevoke answers POST /apikey/{keyID}/revoke
+func apiKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return
+	}
+	k, err := authn.GetAPIKeyByID(id)
+	if err == nil && k.UserID != sessionClaims(r).UserID {
+		err = authz.ErrNotFound
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	if err := k.Revoke(); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "apikey": k})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authn"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//checkRequest is the payload of POST /authz/check
+type checkRequest struct {
+	UserID     uint64           `json:"user_id"`
+	Object     string           `json:"object"`
+	Action     string           `json:"action"`
+	Attributes authz.Attributes `json:"attributes"`
+}
+
+//sendAuthzError translates an authz error into an HTTP error
+func sendAuthzError(w http.ResponseWriter, err error) {
+	code := 500
+	switch err.(type) {
+	case *authz.PathError, *authz.ConditionError:
+		code = 400
+	}
+	switch err {
+	case authz.ErrNotFound:
+		code = 404
+	case authz.ErrAlreadyExists, authz.ErrDomainMismatch, authz.ErrEmptyName, authz.ErrRoleCycle:
+		code = 400
+	}
+	sendHTTP(w, code, map[string]interface{}{"status": "error", "error": err.Error()})
+}
+
+//checkCaller authenticates the caller of a check and returns the user and the scope of the check.
+//Callers send either the X-Domain-Token header of the domain of the user, or an
+//"Authorization: ApiKey <key>" header, the check being then made for the user of the key and
+//restricted to the roles of the key. API keys are stored in SQL only, unusable in file mode.
+//false is returned once an error has been sent
+func checkCaller(w http.ResponseWriter, r *http.Request, userID uint64) (uint64, authz.Scope, bool) {
+	var domain *authz.Domain
+	if token := r.Header.Get(domainTokenHeader); token != "" {
+		d, err := authn.GetDomainFromToken(token)
+		if err != nil {
+			sendAuthnError(w, err)
+			return 0, nil, false
+		}
+		domain = d
+	}
+	var scope authz.Scope
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "ApiKey "):
+		if !authnEnabled {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "API keys need an SQL data source", "code": "authn_disabled"})
+			return 0, nil, false
+		}
+		key, err := authn.AuthenticateAPIKey(strings.TrimSpace(strings.TrimPrefix(auth, "ApiKey ")))
+		if err != nil {
+			if err == authn.ErrInvalidAPIKey {
+				sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "invalid API key", "code": "invalid_api_key"})
+			} else {
+				sendAuthzError(w, err)
+			}
+			return 0, nil, false
+		}
+		if userID != 0 && userID != key.UserID {
+			sendHTTP(w, 403, map[string]interface{}{"status": "error", "error": "API key does not belong to this user"})
+			return 0, nil, false
+		}
+		userID, scope = key.UserID, key.Scope()
+	case domain == nil:
+		sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "domain token or API key required", "code": "unauthenticated"})
+		return 0, nil, false
+	}
+	if domain != nil {
+		//a domain only checks the rights of its own users
+		u, err := authz.GetUserByID(userID)
+		if err == nil && u.DomainID != domain.ID {
+			err = authz.ErrNotFound
+		}
+		if err != nil {
+			sendAuthzError(w, err)
+			return 0, nil, false
+		}
+	}
+	return userID, scope, true
+}
+
+//authzCheck answers POST /authz/check : may a user trigger an action on an object ?
+func authzCheck(w http.ResponseWriter, r *http.Request) {
+	req := checkRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "invalid request : " + err.Error()})
+		return
+	}
+	userID, scope, ok := checkCaller(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	decision, err := authz.Check(userID, req.Object, req.Action, scope, req.Attributes)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "allowed": decision.Allowed, "decision": decision})
+}
+
+//batchCheckRequest is the payload of POST /authz/check/batch
+type batchCheckRequest struct {
+	UserID     uint64            `json:"user_id"`
+	Checks     []authz.CheckItem `json:"checks"`
+	Attributes authz.Attributes  `json:"attributes"`
+}
+
+//maxBatchChecks limits the size of a batch check
+const maxBatchChecks = 1000
+
+//authzCheckBatch answers POST /authz/check/batch : one decision per (action, object) pair
+func authzCheckBatch(w http.ResponseWriter, r *http.Request) {
+	req := batchCheckRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "invalid request : " + err.Error()})
+		return
+	}
+	if len(req.Checks) > maxBatchChecks {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "too many checks in a single batch"})
+		return
+	}
+	userID, scope, ok := checkCaller(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	decisions, err := authz.CheckBatch(userID, req.Checks, scope, req.Attributes)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "decisions": decisions})
+}
+
+//authzCacheStats answers GET /authz/cache/stats with the counters of the per user cache. Admin token required
+func authzCacheStats(w http.ResponseWriter, r *http.Request) {
+	c := authz.GetCache()
+	if c == nil {
+		sendHTTP(w, 404, map[string]interface{}{"status": "error", "error": "cache is disabled"})
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "cache": c.Stats()})
+}
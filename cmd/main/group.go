@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//adminActorHeader optionally names the person behind an admin request, for the audit trail of groups
+const adminActorHeader = "X-Admin-Actor"
+
+//groupRoutes registers the /admin/domain/{domainID}/group routes
+func groupRoutes(router chi.Router) {
+	router.Post("/", groupCreate)
+	router.Get("/", groupList)
+	router.Get("/{groupID}/member", groupMembers)
+	router.Post("/{groupID}/member", groupAddMember)
+	router.Post("/{groupID}/member/{userID}/remove", groupRemoveMember)
+	router.Post("/{groupID}/right", groupGrantRight)
+	router.Get("/{groupID}/audit", groupAudit)
+	router.Post("/{groupID}/delete", groupDelete)
+}
+
+//adminActor describes the author of an admin request
+func adminActor(r *http.Request) string {
+	if actor := r.Header.Get(adminActorHeader); actor != "" {
+		return "admin:" + actor
+	}
+	return "admin"
+}
+
+//groupFromURL reads the group of a route like /domain/{domainID}/group/{groupID}.
+//nil is returned once an error has been sent
+func groupFromURL(w http.ResponseWriter, r *http.Request) *authz.Group {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return nil
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "groupID"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return nil
+	}
+	g, err := authz.GetGroupByID(id)
+	if err == nil && g.DomainID != d.ID {
+		err = authz.ErrNotFound
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return nil
+	}
+	return g
+}
+
+//groupCreate answers POST /admin/domain/{domainID}/group : name and description as post values
+func groupCreate(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	g, err := authz.CreateGroup(d.ID, r.PostFormValue("name"), r.PostFormValue("description"), adminActor(r))
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "group": g})
+}
+
+//groupList answers GET /admin/domain/{domainID}/group
+func groupList(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	groups, err := authz.GetGroups(d.ID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "groups": groups})
+}
+
+//groupMembers answers GET /admin/domain/{domainID}/group/{groupID}/member
+func groupMembers(w http.ResponseWriter, r *http.Request) {
+	g := groupFromURL(w, r)
+	if g == nil {
+		return
+	}
+	members, err := g.GetMembers()
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "members": members})
+}
+
+//groupAddMember answers POST /admin/domain/{domainID}/group/{groupID}/member : user_id as post value
+func groupAddMember(w http.ResponseWriter, r *http.Request) {
+	g := groupFromURL(w, r)
+	if g == nil {
+		return
+	}
+	id, err := strconv.ParseUint(r.PostFormValue("user_id"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "user_id must be an integer"})
+		return
+	}
+	u, err := authz.GetUserByID(id)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	if err := g.AddMember(u, adminActor(r)); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//groupRemoveMember answers POST /admin/domain/{domainID}/group/{groupID}/member/{userID}/remove
+func groupRemoveMember(w http.ResponseWriter, r *http.Request) {
+	g := groupFromURL(w, r)
+	if g == nil {
+		return
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return
+	}
+	if err := g.RemoveMember(id, adminActor(r)); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//groupGrantRight answers POST /admin/domain/{domainID}/group/{groupID}/right : grants or denies
+//a role to the members of the group, see parseRightForm for the post values
+func groupGrantRight(w http.ResponseWriter, r *http.Request) {
+	g := groupFromURL(w, r)
+	if g == nil {
+		return
+	}
+	form, ok := parseRightForm(w, r)
+	if !ok {
+		return
+	}
+	var right *authz.Right
+	var err error
+	switch {
+	case form.deny:
+		right, err = authz.DenyGroupRight(g.ID, form.roleID, form.objectID, form.expiresAt)
+	case form.condition != "":
+		right, err = authz.GrantConditionalGroupRight(g.ID, form.roleID, form.objectID, form.condition, form.expiresAt)
+	default:
+		right, err = authz.GrantGroupRight(g.ID, form.roleID, form.objectID, form.expiresAt)
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "right": right})
+}
+
+//groupAudit answers GET /admin/domain/{domainID}/group/{groupID}/audit
+func groupAudit(w http.ResponseWriter, r *http.Request) {
+	g := groupFromURL(w, r)
+	if g == nil {
+		return
+	}
+	audit, err := g.GetAudit()
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "audit": audit})
+}
+
+//groupDelete answers POST /admin/domain/{domainID}/group/{groupID}/delete : revokes the rights of the group
+func groupDelete(w http.ResponseWriter, r *http.Request) {
+	g := groupFromURL(w, r)
+	if g == nil {
+		return
+	}
+	if err := g.Delete(adminActor(r)); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
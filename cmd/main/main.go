@@ -4,7 +4,8 @@ package main
 
 mauth is a webservice used for authorization, authentication, user registration
 it uses either a file or an sql as permanent storage
-it will use a local cache on a per user basis to store authorization
+it will use a local cache on a per user basis to store authorization. The cache only sees the modifications
+made by its own instance : with several instances, AUTHZ_CACHE_TTL bounds how long they may serve stale rights
 
 mauth regroups users, actions, roles (described below) in Domain.
 users are unique among a specific domain but may be duplicated in different domains.
@@ -15,11 +16,16 @@ Same goes for actions and roles.
 users are represented as User struct.
 Actions represent atomic taks whose access has to be verified.
 Actions are gathered into Roles, to simplify authorization management. One action may belong to multiple roles
+A role may include other roles of its domain : a fleet_manager is a maintainer plus more.
 Actions are applied to Objects. To check if a user may  trigger an action on an object,
 we have to check if the user has the right to. That's what Rights are for :
 a Right is an assignment of a Role to a specific User for a specific object
 Through Rights, any User may be assigned multiple Roles, applying to one Object at least.
+Rights may also be granted to a Group of users of the domain, applying to every member of the group.
 Rights may have an expiration date for temporary access grants
+Deny rights withdraw the actions of a role on an object and its descendants, overriding any grant on the
+same object or a descendant : with a deny on car(123), Bob may maintain every car of garage(28) except car(123),
+even holding a right on car(123)door(1)
 
 Objects are not managed by mauth. When a right is granted to a user, an external object ID has to be provided
 mauth will then store the right as the following struct : (user, role, object_id, optional expiration date)
@@ -38,6 +44,15 @@ Bob, inventory, company(9)
 
 To check Bob's right to trigger an action on a specific car, a call will be maid to mauth service with the following
 parameters : ID of bob, tree of car ID : company(9)garage(28)car(123), task name (carOpen)
+The call is authenticated by the domain token of Bob's domain as header value, or by an API key of Bob
+
+The object_id of a right may use (*) as a wildcard id : a right on company(9)garage(*) applies to every
+garage of company(9), a right on company(*) to every company. Checked objects cannot use wildcards
+
+A granted right may carry a condition on the attributes of the check, such as
+time >= rental_start && time < rental_end && ip in ["10.0.0.0/8"]
+The attributes are posted along with the check, time defaulting to the time of the check.
+The right only applies when its condition holds
 
 ** Authentication model
 
@@ -52,6 +67,33 @@ Request should contain contain :
 
 In return, mauth sends a JWT for the session along with a JWT for renewal
 
+Users with a TOTP second factor, or holding a role requiring one, get a short lived "mfa_token" instead.
+It has to be POSTed to {mauth_url}/user/auth/mfa along with a code of their authenticator app
+or one of their recovery codes. Users who have not enrolled yet use it on {mauth_url}/user/mfa/enroll
+
+** User registration
+
+On domains open to registration, users sign up with POST {mauth_url}/user/register (login, email, password)
+The password has to follow the policy of the domain. A verification token is mailed to the user,
+who has to POST it to {mauth_url}/user/verify before being able to authenticate
+
+Users who forgot their password POST their login to {mauth_url}/user/password/forgot and receive a
+single use token, to be posted along with the new password to {mauth_url}/user/password/reset
+
+** Administration
+
+The /admin routes need the ADMIN_TOKEN of the environment as X-Admin-Token header. Under /admin/domain/{domainID}
+they create the actions, roles, users and groups of a domain, link actions to roles, and grant, deny and revoke
+the rights of users and groups. Users created this way have no password until they go through the password reset
+
+** Storage
+
+With an SQL data source, mauth stores everything in the database.
+A file:// data source only holds the authorization model : authentication (domain keys, sessions,
+refresh tokens, API keys, second factors, password resets) needs an SQL data source.
+In file mode the /user and /apikey routes are not registered, checks cannot use API keys and the
+admin routes managing domain keys and login locks are disabled
+
 
 
 
@@ -60,6 +102,7 @@ In return, mauth sends a JWT for the session along with a JWT for renewal
 */
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -67,18 +110,23 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/marcmorel/morm"
 	"github.com/rs/cors"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/apicall"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authn"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/dispatcher"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/getaround"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/job"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/mail"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/progress"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/slack"
 	"gitlab.com/hiveway/getaround-api-catch/cmd/tools"
@@ -90,6 +138,7 @@ var environment string // either dev / staging / prod
 var serverMode = ""
 var runningMode = ""
 var globalConfig map[string](map[string]string) // will hold major config elements
+var authnEnabled = false                        // authentication needs an SQL data source
 
 func downloadConfig(bucket string, path string) (map[string]string, error) {
 	conf, err := tools.GetContentFromS3(bucket, path)
@@ -131,9 +180,19 @@ func setupConfig() {
 	}
 	globalConfig["proxy"] = proxyconfig
 }
+
+// envInt reads an integer from the environment, def being used when it is missing or invalid
+func envInt(name string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
 func main() {
 
-	morm.InitModels(
+	morm.InitModels(append(
 		[]interface{}{
 			&getaround.Account{},
 			&getaround.Alert{},
@@ -142,11 +201,13 @@ func main() {
 			&getaround.Picture{},
 			&getaround.LocationHistory{},
 			&job.Job{},
-		})
+		}, append(authz.Models(), authn.Models()...)...))
 
 	//init event dispatcher
-	dispatcher.CreateChannel("getaround")
-	dispatcher.Channels["getaround"].Subscribe("", eventlog)
+	dispatcher.GetOrCreate("getaround").Subscribe("", eventlog)
+	authn.Events.Subscribe("security.", eventlog)
+	authn.Events.Subscribe("password.", eventlog)
+	authz.Events.Subscribe("right.expir", eventlog)
 	setupConfig()
 	fmt.Printf("Starting in " + environment + " environment as a " + runningMode + "\n")
 
@@ -159,18 +220,44 @@ func main() {
 	slack.InitChannels(slackMap)
 	delayAlert := 180
 	delayCalendar := 400
+	delayRights := envInt("RIGHTS_SWEEP_DELAY", 60)
+	authz.ExpiryWarning = time.Duration(envInt("RIGHTS_EXPIRY_WARNING", int(authz.ExpiryWarning/time.Second))) * time.Second
 
-	getaround.MigrateDB(dataSource)
-	if _, err := morm.InitDB(dataSource); err != nil {
-		fmt.Printf("Error in DB connection :%s\n", err.Error())
+	authzStore, err := authz.NewStore(dataSource)
+	if err != nil {
+		fmt.Printf("Error in authz storage :%s\n", err.Error())
 		os.Exit(1)
 	}
+	authz.SetStore(authzStore)
+	if authz.IsFileDataSource(dataSource) {
+		//the flat file only holds the authorization model : the authentication routes are not registered
+		fmt.Printf("Authorization model stored in %s, authentication and getaround features need an SQL data source\n", dataSource)
+	} else {
+		authnEnabled = true
+		getaround.MigrateDB(dataSource)
+		if err := authz.MigrateDB(dataSource); err != nil {
+			fmt.Printf("Error in authz migration :%s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := authn.MigrateDB(dataSource); err != nil {
+			fmt.Printf("Error in authn migration :%s\n", err.Error())
+			os.Exit(1)
+		}
+		if _, err := morm.InitDB(dataSource); err != nil {
+			fmt.Printf("Error in DB connection :%s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := tools.InitDB(dataSource); err != nil {
+			fmt.Printf("Error in DB connection :%s\n", err.Error())
+			os.Exit(1)
+		}
+	}
 
 	getaroundWrapper, _ = apicall.CreateWrapper(globalConfig["proxy"])
 
 	var wg sync.WaitGroup
 	if runningMode == "worker" {
-		wg.Add(3)
+		wg.Add(4)
 		slack.PublishTo("igor",
 			"Igor a redémarré en mode worker et en environnement "+environment+" à "+
 				time.Now().Format("15:04:05")+" UTC. Les alertes sont surveillées toutes les "+
@@ -189,6 +276,11 @@ func main() {
 			updateEvents(getaroundWrapper, delayCalendar)
 			wg.Done()
 		}()
+		go func() {
+			fmt.Printf("Starting rights sweeper thread\n")
+			sweepRights(delayRights)
+			wg.Done()
+		}()
 		go func() {
 			fmt.Printf("Starting health check server\n")
 			initHealthOnlyServer()
@@ -206,8 +298,35 @@ func main() {
 		}()
 	}
 
-	wg.Wait()
+	//run until every thread ends or a termination signal is received
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-finished:
+	case s := <-signals:
+		fmt.Printf("Received %s, shutting down\n", s)
+	}
+	shutdownEvents(time.Duration(envInt("SHUTDOWN_TIMEOUT", 10)) * time.Second)
+}
 
+// shutdownEvents closes the event channels, waiting at most timeout for the pending events to be handled
+func shutdownEvents(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, name := range dispatcher.List() {
+		c, ok := dispatcher.Get(name)
+		if !ok {
+			continue
+		}
+		if err := c.Close(ctx); err != nil {
+			fmt.Printf("Events of channel %s not handled before shutdown : %s\n", name, err.Error())
+		}
+	}
 }
 
 func initHealthOnlyServer() {
@@ -339,6 +458,29 @@ func initServer(wrapper *apicall.Wrapper) {
 		port = "80"
 	}
 
+	//per user authorization cache. TTL in seconds
+	authz.EnableCache(time.Duration(envInt("AUTHZ_CACHE_TTL", 300))*time.Second, envInt("AUTHZ_CACHE_SIZE", 10000))
+	//algorithm of new password hashes, argon2id or bcrypt. Older hashes are upgraded on login
+	if algorithm := os.Getenv("PASSWORD_ALGORITHM"); algorithm != "" {
+		authn.PasswordAlgorithm = algorithm
+	}
+	//how long rotated domain keys keep verifying tokens, in seconds
+	authn.KeyRotationGrace = time.Duration(envInt("KEY_ROTATION_GRACE", int(authn.KeyRotationGrace/time.Second))) * time.Second
+	//failed authentications locking a login or an IP, and duration of the lock in seconds
+	authn.LoginLockThreshold = envInt("LOGIN_LOCK_THRESHOLD", authn.LoginLockThreshold)
+	authn.IPLockThreshold = envInt("IP_LOCK_THRESHOLD", authn.IPLockThreshold)
+	authn.LockDuration = time.Duration(envInt("LOCK_DURATION", int(authn.LockDuration/time.Second))) * time.Second
+	//emails hold verification and reset tokens : they are only logged in dev, to MAIL_LOG or the standard
+	//output, or elsewhere when MAIL_LOG is set explicitly. Registration and password resets are disabled
+	//otherwise, until a real sender is plugged
+	if mailLog := os.Getenv("MAIL_LOG"); environment == "dev" || mailLog != "" {
+		authn.MailSender = mail.LogSender{Path: mailLog}
+	} else {
+		fmt.Printf("No mail sender in " + environment + " : registration and password resets are disabled\n")
+	}
+	authn.VerificationURL = os.Getenv("VERIFICATION_URL")
+	authn.ResetURL = os.Getenv("RESET_URL")
+
 	router := chi.NewRouter()
 	// Add CORS middleware around every request
 	// See https://github.com/rs/cors for full option listing
@@ -346,6 +488,7 @@ func initServer(wrapper *apicall.Wrapper) {
 		AllowedOrigins:     []string{"*"},
 		Debug:              false,
 		AllowedMethods:     []string{"GET", "POST", "OPTIONS"},
+		AllowedHeaders:     []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "Authorization", domainTokenHeader, adminTokenHeader, adminActorHeader},
 		OptionsPassthrough: false,
 		AllowCredentials:   true}).Handler)
 
@@ -426,6 +569,25 @@ func initServer(wrapper *apicall.Wrapper) {
 
 	router.Get("/rental/{rentalID}", rentalDetail)
 
+	router.Post("/authz/check", authzCheck)
+	router.Post("/authz/check/batch", authzCheckBatch)
+	router.With(requireAdmin).Get("/authz/cache/stats", authzCacheStats)
+
+	if authnEnabled {
+		router.Post("/user/register", userRegister)
+		router.Post("/user/verify", userVerify)
+		router.Post("/user/verify/resend", userResendVerification)
+		router.Post("/user/password/forgot", userForgotPassword)
+		router.Post("/user/password/reset", userResetPassword)
+		router.Post("/user/auth", userAuth)
+		router.Post("/user/auth/mfa", userAuthMFA)
+		router.Route("/user/mfa", mfaRoutes)
+		router.Post("/user/refresh", userRefresh)
+		router.Route("/apikey", apiKeyRoutes)
+	}
+
+	router.Route("/admin", adminRoutes)
+
 	router.Get("/unavailable_cars", func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		end := start.AddDate(0, 0, 7)
@@ -528,6 +690,20 @@ func updateEvents(w *apicall.Wrapper, delaySecond int) {
 
 }
 
+func sweepRights(delaySecond int) {
+	fmt.Printf("Rights sweeper started\n")
+	for {
+		expiring, expired, err := authz.SweepExpiredRights(time.Now())
+		if err != nil {
+			fmt.Printf("Error on SweepExpiredRights %s\n", err.Error())
+		}
+		if expiring > 0 || expired > 0 {
+			fmt.Printf("Rights sweeper : %d expiring, %d expired and archived\n", expiring, expired)
+		}
+		time.Sleep(time.Duration(delaySecond) * time.Second)
+	}
+}
+
 func eventlog(eventname string, payload interface{}) {
 	fmt.Printf("Received event:%s\n", eventname)
 
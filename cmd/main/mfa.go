@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authn"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//mfaRoutes registers the /user/mfa routes. Enrollment also accepts the pending token given
+//by /user/auth to users who must enroll a second factor before getting a session
+func mfaRoutes(router chi.Router) {
+	router.Group(func(router chi.Router) {
+		router.Use(func(next http.Handler) http.Handler {
+			return requireToken(next, authn.TokenAccess, authn.TokenMFAEnroll)
+		})
+		router.Post("/enroll", mfaEnroll)
+		router.Post("/confirm", mfaConfirm)
+	})
+	router.Group(func(router chi.Router) {
+		router.Use(requireSession)
+		router.Post("/disable", mfaDisable)
+		router.Post("/recovery", mfaRecoveryCodes)
+	})
+}
+
+//sessionUser returns the user of an authenticated request. false is returned once an error has been sent
+func sessionUser(w http.ResponseWriter, r *http.Request) (*authz.User, bool) {
+	u, err := authz.GetUserByID(sessionClaims(r).UserID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return nil, false
+	}
+	return u, true
+}
+
+//userAuthMFA answers POST /user/auth/mfa : second step of /user/auth, mfa_token and code as post values.
+//The code is either a TOTP code or a recovery code
+func userAuthMFA(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	tokens, err := authn.CompleteMFA(domain, r.PostFormValue("mfa_token"), r.PostFormValue("code"))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "tokens": tokens})
+}
+
+//mfaEnroll answers POST /user/mfa/enroll : returns a new TOTP secret and its otpauth:// URI
+func mfaEnroll(w http.ResponseWriter, r *http.Request) {
+	u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	secret, uri, err := authn.EnrollTOTP(u)
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "secret": secret, "uri": uri})
+}
+
+//mfaConfirm answers POST /user/mfa/confirm : activates the second factor with a first code and returns
+//the recovery codes. Users enrolling with a pending token receive their session tokens as well
+func mfaConfirm(w http.ResponseWriter, r *http.Request) {
+	u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	codes, err := authn.ConfirmTOTP(u, r.PostFormValue("code"))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	result := map[string]interface{}{"status": "ok", "recovery_codes": codes}
+	if sessionClaims(r).Type == authn.TokenMFAEnroll {
+		tokens, err := authn.IssueTokens(u)
+		if err != nil {
+			sendAuthnError(w, err)
+			return
+		}
+		//the authentication started by /user/auth is complete : its failures are forgotten as in userAuth
+		authn.RecordSuccess(u.DomainID, u.Login)
+		result["tokens"] = tokens
+	}
+	sendHTTP(w, 200, result)
+}
+
+//mfaDisable answers POST /user/mfa/disable : a valid code is required
+func mfaDisable(w http.ResponseWriter, r *http.Request) {
+	u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if err := authn.DisableTOTP(u, r.PostFormValue("code")); err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//mfaRecoveryCodes answers POST /user/mfa/recovery : replaces the recovery codes of the user
+func mfaRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	codes, err := authn.RegenerateRecoveryCodes(u)
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "recovery_codes": codes})
+}
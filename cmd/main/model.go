@@ -0,0 +1,315 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//userAdminRoutes registers the /admin/domain/{domainID}/user routes
+func userAdminRoutes(router chi.Router) {
+	router.Post("/", adminCreateUser)
+	router.Get("/", adminListUsers)
+	router.Get("/{userID}/right", adminUserRights)
+	router.Post("/{userID}/right", adminGrantUserRight)
+	router.Post("/{userID}/right/{rightID}/revoke", adminRevokeUserRight)
+}
+
+//rightForm holds the post values describing a right
+type rightForm struct {
+	roleID    uint64
+	objectID  string
+	expiresAt *time.Time
+	deny      bool
+	condition string
+}
+
+//parseRightForm reads the post values of a right : role_id, object_id, expires_at (RFC3339, optional),
+//deny (boolean, optional), condition (optional, granted rights only). false is returned once an error has been sent
+func parseRightForm(w http.ResponseWriter, r *http.Request) (rightForm, bool) {
+	form := rightForm{objectID: r.PostFormValue("object_id"), condition: r.PostFormValue("condition")}
+	var err error
+	form.roleID, err = strconv.ParseUint(r.PostFormValue("role_id"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "role_id must be an integer"})
+		return form, false
+	}
+	if v := r.PostFormValue("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "expires_at must be a RFC3339 date"})
+			return form, false
+		}
+		form.expiresAt = &t
+	}
+	if v := r.PostFormValue("deny"); v != "" {
+		if form.deny, err = strconv.ParseBool(v); err != nil {
+			sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "deny must be a boolean"})
+			return form, false
+		}
+	}
+	if form.deny && form.condition != "" {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "deny rights cannot have a condition"})
+		return form, false
+	}
+	return form, true
+}
+
+//userFromURL reads the user of a route like /domain/{domainID}/user/{userID}.
+//nil is returned once an error has been sent
+func userFromURL(w http.ResponseWriter, r *http.Request) *authz.User {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return nil
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return nil
+	}
+	u, err := authz.GetUserByID(id)
+	if err == nil && u.DomainID != d.ID {
+		err = authz.ErrNotFound
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return nil
+	}
+	return u
+}
+
+//actionFromID reads an action of the domain from its ID. nil is returned once an error has been sent
+func actionFromID(w http.ResponseWriter, d *authz.Domain, value string) *authz.Action {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return nil
+	}
+	a, err := authz.GetActionByID(id)
+	if err == nil && a.DomainID != d.ID {
+		err = authz.ErrNotFound
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return nil
+	}
+	return a
+}
+
+//adminCreateUser answers POST /admin/domain/{domainID}/user : login and email as post values.
+//The user has no password : they set one through the password reset
+func adminCreateUser(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	u, err := authz.CreateUser(d.ID, r.PostFormValue("login"), r.PostFormValue("email"))
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "user": u})
+}
+
+//adminListUsers answers GET /admin/domain/{domainID}/user
+func adminListUsers(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	users, err := authz.GetUsers(d.ID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "users": users})
+}
+
+//adminUserRights answers GET /admin/domain/{domainID}/user/{userID}/right : the rights held by the user
+//themselves, not through their groups
+func adminUserRights(w http.ResponseWriter, r *http.Request) {
+	u := userFromURL(w, r)
+	if u == nil {
+		return
+	}
+	rights, err := authz.GetUserRights(u.ID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "rights": rights})
+}
+
+//adminGrantUserRight answers POST /admin/domain/{domainID}/user/{userID}/right : grants or denies
+//a role to the user, see parseRightForm for the post values
+func adminGrantUserRight(w http.ResponseWriter, r *http.Request) {
+	u := userFromURL(w, r)
+	if u == nil {
+		return
+	}
+	form, ok := parseRightForm(w, r)
+	if !ok {
+		return
+	}
+	var right *authz.Right
+	var err error
+	switch {
+	case form.deny:
+		right, err = authz.DenyRight(u.ID, form.roleID, form.objectID, form.expiresAt)
+	case form.condition != "":
+		right, err = authz.GrantConditionalRight(u.ID, form.roleID, form.objectID, form.condition, form.expiresAt)
+	default:
+		right, err = authz.GrantRight(u.ID, form.roleID, form.objectID, form.expiresAt)
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "right": right})
+}
+
+//adminRevokeUserRight answers POST /admin/domain/{domainID}/user/{userID}/right/{rightID}/revoke.
+//Granted and deny rights are both removed this way
+func adminRevokeUserRight(w http.ResponseWriter, r *http.Request) {
+	u := userFromURL(w, r)
+	if u == nil {
+		return
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "rightID"), 10, 64)
+	if err != nil {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "ID must be an integer"})
+		return
+	}
+	right, err := authz.GetRightByID(id)
+	if err == nil && right.UserID != u.ID {
+		err = authz.ErrNotFound
+	}
+	if err == nil {
+		err = right.Revoke()
+	}
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//adminCreateAction answers POST /admin/domain/{domainID}/action : name and description as post values
+func adminCreateAction(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	a, err := authz.CreateAction(d.ID, r.PostFormValue("name"), r.PostFormValue("description"))
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "action": a})
+}
+
+//adminListActions answers GET /admin/domain/{domainID}/action
+func adminListActions(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	actions, err := authz.GetActions(d.ID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "actions": actions})
+}
+
+//adminCreateRole answers POST /admin/domain/{domainID}/role : name and description as post values
+func adminCreateRole(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role, err := authz.CreateRole(d.ID, r.PostFormValue("name"), r.PostFormValue("description"))
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "role": role})
+}
+
+//adminListRoles answers GET /admin/domain/{domainID}/role
+func adminListRoles(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	roles, err := authz.GetRoles(d.ID)
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "roles": roles})
+}
+
+//adminRoleActions answers GET /admin/domain/{domainID}/role/{roleID}/action : the actions linked to the role,
+//without those of the roles it includes
+func adminRoleActions(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role := roleFromID(w, d, chi.URLParam(r, "roleID"))
+	if role == nil {
+		return
+	}
+	actions, err := role.GetActions()
+	if err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "actions": actions})
+}
+
+//adminRoleAddAction answers POST /admin/domain/{domainID}/role/{roleID}/action : links the action_id post value to the role
+func adminRoleAddAction(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role := roleFromID(w, d, chi.URLParam(r, "roleID"))
+	if role == nil {
+		return
+	}
+	a := actionFromID(w, d, r.PostFormValue("action_id"))
+	if a == nil {
+		return
+	}
+	if err := role.AddAction(a); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//adminRoleRemoveAction answers POST /admin/domain/{domainID}/role/{roleID}/action/{actionID}/remove
+func adminRoleRemoveAction(w http.ResponseWriter, r *http.Request) {
+	d := domainFromURL(w, r)
+	if d == nil {
+		return
+	}
+	role := roleFromID(w, d, chi.URLParam(r, "roleID"))
+	if role == nil {
+		return
+	}
+	a := actionFromID(w, d, chi.URLParam(r, "actionID"))
+	if a == nil {
+		return
+	}
+	if err := role.RemoveAction(a); err != nil {
+		sendAuthzError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
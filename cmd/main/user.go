@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authn"
+	"gitlab.com/hiveway/getaround-api-catch/cmd/authz"
+)
+
+//domainTokenHeader is the header holding the token of the domain the app belongs to
+const domainTokenHeader = "X-Domain-Token"
+
+//contextKey is the type of the values stored by mauth in request contexts
+type contextKey string
+
+//claimsContextKey holds the claims of the access token of an authenticated request
+const claimsContextKey = contextKey("claims")
+
+//requireSession is a middleware rejecting requests without a valid access token
+//sent as "Authorization: Bearer <token>" along with the domain token
+func requireSession(next http.Handler) http.Handler {
+	return requireToken(next, authn.TokenAccess)
+}
+
+//requireToken is a middleware rejecting requests without a valid bearer token of one of the given types
+func requireToken(next http.Handler, tokenTypes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+		if err != nil {
+			sendAuthnError(w, err)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			sendAuthnError(w, authn.ErrInvalidToken)
+			return
+		}
+		var claims *authn.Claims
+		for _, tokenType := range tokenTypes {
+			claims, err = authn.ParseToken(domain, strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), tokenType)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			sendAuthnError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+	})
+}
+
+//trustedProxies is the number of proxies in front of mauth appending the address of their client to
+//X-Forwarded-For. TRUST_FORWARDED_FOR holds that number, "true" meaning a single proxy
+var trustedProxies = parseTrustedProxies(os.Getenv("TRUST_FORWARDED_FOR"))
+
+func parseTrustedProxies(value string) int {
+	if value == "true" {
+		return 1
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+//clientIP returns the address of the client of a request. Behind trusted proxies it is the address
+//appended by the farthest of them, counting from the right of X-Forwarded-For : the entries on its
+//left come from the client and may be forged
+func clientIP(r *http.Request) string {
+	if trustedProxies > 0 {
+		var entries []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(header, ",") {
+				entries = append(entries, strings.TrimSpace(entry))
+			}
+		}
+		if len(entries) > 0 {
+			i := len(entries) - trustedProxies
+			if i < 0 {
+				i = 0
+			}
+			if net.ParseIP(entries[i]) != nil {
+				return entries[i]
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//sessionClaims returns the claims of a request authenticated by requireSession
+func sessionClaims(r *http.Request) *authn.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*authn.Claims)
+	return claims
+}
+
+//sendAuthnError translates an authn error into an HTTP error. Credential errors all share the same body
+func sendAuthnError(w http.ResponseWriter, err error) {
+	if throttleErr, ok := err.(*authn.ThrottleError); ok {
+		retryAfter := int(math.Ceil(throttleErr.RetryAfter.Seconds()))
+		code := "throttled"
+		if throttleErr.Locked {
+			code = "locked"
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		sendHTTP(w, 429, map[string]interface{}{"status": "error", "error": "too many failed attempts", "code": code,
+			"retry_after": retryAfter})
+		return
+	}
+	if policyErr, ok := err.(*authn.PolicyError); ok {
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": err.Error(), "code": "weak_password", "rules": policyErr.Rules})
+		return
+	}
+	switch err {
+	case authn.ErrInvalidCredentials:
+		sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "invalid credentials", "code": "invalid_credentials"})
+	case authn.ErrInvalidDomain:
+		sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "invalid domain token", "code": "invalid_domain"})
+	case authn.ErrInvalidToken:
+		sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "invalid token", "code": "invalid_token"})
+	case authn.ErrTokenReused:
+		sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "refresh token already used, session revoked", "code": "token_reused"})
+	case authn.ErrUnverifiedUser:
+		sendHTTP(w, 403, map[string]interface{}{"status": "error", "error": "email not verified yet", "code": "unverified_user"})
+	case authn.ErrRegistrationClosed:
+		sendHTTP(w, 403, map[string]interface{}{"status": "error", "error": "registration is closed on this domain", "code": "registration_closed"})
+	case authn.ErrInvalidEmail:
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "invalid email", "code": "invalid_email"})
+	case authn.ErrMailUnavailable:
+		sendHTTP(w, 503, map[string]interface{}{"status": "error", "error": "emails cannot be sent", "code": "mail_unavailable"})
+	case authn.ErrMFARequired:
+		sendHTTP(w, 403, map[string]interface{}{"status": "error", "error": "a role of the user requires a second factor", "code": "mfa_required"})
+	case authn.ErrMFAAlreadyEnabled:
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "second factor already enabled", "code": "mfa_already_enabled"})
+	case authn.ErrMFANotEnrolled:
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "no second factor enrolled", "code": "mfa_not_enrolled"})
+	case authn.ErrInvalidMFACode:
+		sendHTTP(w, 401, map[string]interface{}{"status": "error", "error": "invalid second factor code", "code": "invalid_mfa_code"})
+	case authz.ErrAlreadyExists:
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "login already used", "code": "login_taken"})
+	case authz.ErrEmptyName:
+		sendHTTP(w, 400, map[string]interface{}{"status": "error", "error": "login cannot be empty", "code": "invalid_login"})
+	default:
+		sendHTTP(w, 500, map[string]interface{}{"status": "error", "error": err.Error(), "code": "internal_error"})
+	}
+}
+
+//userAuth answers POST /user/auth : login and password as post values, domain token as header
+func userAuth(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	login := r.PostFormValue("login")
+	ip := clientIP(r)
+	if err := authn.CheckThrottle(domain.ID, login, ip); err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	u, err := authn.Authenticate(domain, login, r.PostFormValue("password"))
+	if err == authn.ErrInvalidCredentials {
+		authn.RecordFailure(domain.ID, login, ip)
+	}
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	session, err := authn.StartSession(u)
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	if session.Tokens == nil {
+		//second step : POST /user/auth/mfa, or /user/mfa/enroll when a role requires a second factor.
+		//Failures of the login are only forgotten once the second factor is checked too
+		sendHTTP(w, 200, map[string]interface{}{"status": "mfa_pending", "mfa": session.MFA,
+			"mfa_token": session.MFAToken, "expires_in": session.ExpiresIn})
+		return
+	}
+	authn.RecordSuccess(domain.ID, login)
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "tokens": session.Tokens})
+}
+
+//userRefresh answers POST /user/refresh : exchanges a refresh token for a new pair of tokens
+func userRefresh(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	tokens, err := authn.Refresh(domain, r.PostFormValue("refresh_token"))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "tokens": tokens})
+}
+
+//userRegister answers POST /user/register : login, email and password as post values, domain token as header.
+//The user receives a verification token by email and cannot authenticate before using it
+func userRegister(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	u, err := authn.Register(domain, r.PostFormValue("login"), r.PostFormValue("email"), r.PostFormValue("password"))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "user": u})
+}
+
+//userVerify answers POST /user/verify : the verification token as post value
+func userVerify(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	u, err := authn.VerifyEmail(domain, r.PostFormValue("token"))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok", "user": u})
+}
+
+//userResendVerification answers POST /user/verify/resend : login as post value.
+//The answer is the same whether the login exists or not
+func userResendVerification(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	if err := authn.ResendVerification(domain, r.PostFormValue("login")); err != nil && err != authz.ErrNotFound {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//userForgotPassword answers POST /user/password/forgot : login as post value.
+//A reset token is mailed to the user. The answer is the same whether the login exists or not
+func userForgotPassword(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	if err := authn.RequestPasswordReset(domain, r.PostFormValue("login")); err != nil && err != authz.ErrNotFound {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
+
+//userResetPassword answers POST /user/password/reset : reset token and new password as post values.
+//Every session of the user is revoked
+func userResetPassword(w http.ResponseWriter, r *http.Request) {
+	domain, err := authn.GetDomainFromToken(r.Header.Get(domainTokenHeader))
+	if err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	if _, err := authn.ResetPassword(domain, r.PostFormValue("token"), r.PostFormValue("password")); err != nil {
+		sendAuthnError(w, err)
+		return
+	}
+	sendHTTP(w, 200, map[string]interface{}{"status": "ok"})
+}
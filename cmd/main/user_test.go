@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := map[string]int{"": 0, "false": 0, "true": 1, "0": 0, "2": 2, "-1": 0, "x": 0}
+	for value, want := range tests {
+		if got := parseTrustedProxies(value); got != want {
+			t.Errorf("parseTrustedProxies(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		proxies   int
+		forwarded []string
+		want      string
+	}{
+		//X-Forwarded-For is ignored unless proxies are trusted
+		{0, []string{"203.0.113.9"}, "192.0.2.1"},
+		{1, nil, "192.0.2.1"},
+		{1, []string{"203.0.113.9"}, "203.0.113.9"},
+		//the client forged the leftmost entry, the proxy appended its real address
+		{1, []string{"10.0.0.1, 203.0.113.9"}, "203.0.113.9"},
+		{1, []string{"10.0.0.1", "203.0.113.9"}, "203.0.113.9"},
+		//two proxies : the second appended the address of the first
+		{2, []string{"10.0.0.1, 203.0.113.9, 198.51.100.7"}, "203.0.113.9"},
+		{2, []string{"203.0.113.9"}, "203.0.113.9"},
+		{1, []string{"10.0.0.1, 2001:db8::1"}, "2001:db8::1"},
+		//an entry which is not an address is not trusted
+		{1, []string{"10.0.0.1, unknown"}, "192.0.2.1"},
+	}
+	previous := trustedProxies
+	defer func() { trustedProxies = previous }()
+	for _, test := range tests {
+		trustedProxies = test.proxies
+		r := httptest.NewRequest("POST", "/user/auth", nil)
+		r.RemoteAddr = "192.0.2.1:4321"
+		for _, v := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r); got != test.want {
+			t.Errorf("clientIP with %d proxies and %q = %s, want %s", test.proxies, test.forwarded, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"archive/zip"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -25,6 +26,12 @@ func RandomHex(n int) string {
 	return hex.EncodeToString(bytes)
 }
 
+//HashToken returns the hex encoded sha256 of a secret token, to be stored instead of the token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 //ConvertStringToAmount function converts a string amount into an int64 of CENTS.
 //we use that trick to avoid any float in the app (and rounding issues)
 // " - 1234,43 " --> -123443
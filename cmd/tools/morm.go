@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	//mysql driver used by the migrations
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/marcmorel/morm"
+)
+
+//ErrNotFound is returned by FindOneRow when no row matches
+var ErrNotFound = errors.New("Not found")
+
+//ErrNoDB is returned by Exec before InitDB is called
+var ErrNoDB = errors.New("Database not initialized")
+
+//db runs the statements morm cannot express, such as conditional updates
+var db *sql.DB
+
+//QuoteValue returns a string value usable as a morm column filter
+func QuoteValue(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+//FormatID returns the string version of an ID, for morm filters
+func FormatID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+//FindAllRows runs a morm query on a table and returns the rows, with the column names
+//stripped of their table prefix
+func FindAllRows(table string, filters map[string]string) ([]map[string]interface{}, error) {
+	arrmaps, err := morm.FindAllByColumn(table, filters)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[string]interface{}, len(arrmaps))
+	for i, m := range arrmaps {
+		result[i] = make(map[string]interface{}, len(m))
+		for k, v := range m {
+			result[i][strings.TrimPrefix(k, table+".")] = v
+		}
+	}
+	return result, nil
+}
+
+//FindOneRow runs a morm query on a table and returns the first row or ErrNotFound
+func FindOneRow(table string, filters map[string]string) (map[string]interface{}, error) {
+	filters["morm_limit"] = "1"
+	rows, err := FindAllRows(table, filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+//RowUint reads an ID column from a row
+func RowUint(row map[string]interface{}, column string) uint64 {
+	id, _ := strconv.ParseUint(morm.SafeString(row[column]), 10, 64)
+	return id
+}
+
+//RowInt reads an integer column from a row
+func RowInt(row map[string]interface{}, column string) int {
+	v, _ := strconv.Atoi(morm.SafeString(row[column]))
+	return v
+}
+
+//RowBool reads a boolean column from a row
+func RowBool(row map[string]interface{}, column string) bool {
+	v := morm.SafeString(row[column])
+	return v == "1" || v == "true"
+}
+
+//RowTime reads a mandatory date column from a row
+func RowTime(row map[string]interface{}, column string) time.Time {
+	t := morm.SafeTime(row[column])
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+//MigrateDB plays a list of idempotent sql statements on the database
+func MigrateDB(dataSource string, migrations []string) error {
+	db, err := sql.Open("mysql", dataSource)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, m := range migrations {
+		if _, err := db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//InitDB opens the connection used by Exec
+func InitDB(dataSource string) error {
+	conn, err := sql.Open("mysql", dataSource)
+	if err != nil {
+		return err
+	}
+	db = conn
+	return nil
+}
+
+//Exec runs a statement and returns the number of affected rows
+func Exec(query string, args ...interface{}) (int64, error) {
+	if db == nil {
+		return 0, ErrNoDB
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}